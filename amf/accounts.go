@@ -0,0 +1,221 @@
+package amf
+
+import (
+	"blockchain_A3/core"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrUnsplittable is returned when a shard's load cannot be divided: it all
+// sits on one account, or on transactions relocated there by transfers
+var ErrUnsplittable = errors.New("shard load cannot be divided")
+
+// splitTolerance is how far from half the load a split may cut to keep
+// related accounts together
+const splitTolerance = 0.2
+
+// SplitReport describes the accounts a split weighed and the fraction of the
+// shard's transactions predicted to be cross-shard if their accounts
+// transacted again, before and after the split
+type SplitReport struct {
+	HotAccounts      []KeyCount
+	CrossShardBefore float64
+	CrossShardAfter  float64
+}
+
+func (r SplitReport) String() string {
+	return fmt.Sprintf("cross-shard %.0f%% -> %.0f%%, hot accounts %v",
+		r.CrossShardBefore*100, r.CrossShardAfter*100, r.HotAccounts)
+}
+
+// Accounts returns the sender and receiver of a transaction, read from a
+// canonical core.Transaction encoding or a "sender -> receiver: amount"
+// payload, or ok false if the transaction names no accounts
+func Accounts(tx []byte) (sender, receiver string, ok bool) {
+	var decoded core.Transaction
+	if err := decoded.UnmarshalBinary(tx); err == nil {
+		return decoded.Sender, decoded.Receiver, true
+	}
+	sender, rest, found := strings.Cut(string(tx), "->")
+	if !found {
+		return "", "", false
+	}
+	receiver, _, _ = strings.Cut(rest, ":")
+	sender, receiver = strings.TrimSpace(sender), strings.TrimSpace(receiver)
+	if sender == "" || receiver == "" {
+		return "", "", false
+	}
+	return sender, receiver, true
+}
+
+// HotAccounts counts how often each account sends or receives among txs and
+// returns the most frequent, busiest first, skipping accounts seen only once
+func HotAccounts(txs [][]byte, n int) []KeyCount {
+	counts := make(map[string]int)
+	for _, tx := range txs {
+		sender, receiver, ok := Accounts(tx)
+		if !ok {
+			continue
+		}
+		counts[sender]++
+		if receiver != sender {
+			counts[receiver]++
+		}
+	}
+	var hot []KeyCount
+	for account, count := range counts {
+		if count > 1 {
+			hot = append(hot, KeyCount{account, count})
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].Count != hot[j].Count {
+			return hot[i].Count > hot[j].Count
+		}
+		return hot[i].Key < hot[j].Key
+	})
+	if len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+// crossShardRatio predicts the fraction of txs that would cross shards if
+// the accounts they pair were transferring again, given each account's shard
+func crossShardRatio(txs [][]byte, home func(account string) int) float64 {
+	if len(txs) == 0 {
+		return 0
+	}
+	cross := 0
+	for _, tx := range txs {
+		if sender, receiver, ok := Accounts(tx); ok && home(sender) != home(receiver) {
+			cross++
+		}
+	}
+	return float64(cross) / float64(len(txs))
+}
+
+// splitPlan is where a split cuts a shard's ring ranges
+type splitPlan struct {
+	boundary uint64
+	// offset orders positions within the shard's arcs
+	offset func(uint64) uint64
+	report SplitReport
+}
+
+// planSplit chooses the ring position up to which shard's accounts move to a
+// new shard. Among the cuts within splitTolerance of half the load it takes
+// the one that leaves the fewest of the shard's transactions between
+// accounts on different shards, so hot accounts stay with their
+// counterparties; failing that it takes the cut closest to half.
+func (sm *ShardManager) planSplit(shard *Shard) (splitPlan, error) {
+	// Transactions relocated into shard by a transfer stay where they are,
+	// and an account's transactions share its ring point, so move as one
+	type group struct {
+		pos  uint64
+		load float64
+	}
+	loads := sm.keyLoads(shard)
+	var total float64
+	for _, load := range loads {
+		total += load
+	}
+	if total == 0 {
+		// nothing held carries load under the metric, e.g. no recent
+		// throughput, so divide the transactions instead
+		for key := range loads {
+			loads[key] = 1
+		}
+		total = float64(len(loads))
+	}
+
+	groups := make(map[uint64]*group)
+	var order []*group
+	movable := 0
+	for _, tx := range shard.Transactions {
+		pos := KeyPoint(PlacementKey(tx))
+		if sm.ring.Owner(pos) != shard.ID {
+			continue
+		}
+		g, ok := groups[pos]
+		if !ok {
+			g = &group{pos: pos}
+			groups[pos] = g
+			order = append(order, g)
+		}
+		g.load += loads[string(tx)]
+		movable++
+	}
+	if len(order) == 0 {
+		return splitPlan{}, fmt.Errorf("%w: shard %d only holds relocated transactions", ErrUnsplittable, shard.ID)
+	}
+
+	// Order accounts by ring position starting just after the arc preceding
+	// one of the shard's points, so the shard's arcs never wrap in that order
+	start := sm.ring.arcStart(shard.ID)
+	offset := func(p uint64) uint64 { return p - start - 1 }
+	sort.Slice(order, func(i, j int) bool { return offset(order[i].pos) < offset(order[j].pos) })
+
+	// home places an account as if the accounts up to cut had moved
+	home := func(cut uint64) func(string) int {
+		return func(account string) int {
+			pos := KeyPoint([]byte(account))
+			owner := sm.ring.Owner(pos)
+			if owner == shard.ID && offset(pos) <= cut {
+				return -1 // the new shard
+			}
+			return owner
+		}
+	}
+
+	type candidate struct {
+		boundary  uint64
+		balanced  bool
+		gap       float64
+		crossRate float64
+	}
+	better := func(a, b candidate) bool {
+		if a.balanced != b.balanced {
+			return a.balanced
+		}
+		if a.balanced && a.crossRate != b.crossRate {
+			return a.crossRate < b.crossRate
+		}
+		return a.gap < b.gap
+	}
+	var best *candidate
+	var moved float64
+	for i, g := range order {
+		// Something must stay behind for the split to relieve the shard
+		if i == len(order)-1 && movable == shard.Load {
+			break
+		}
+		moved += g.load
+		c := candidate{boundary: g.pos, gap: math.Abs(moved - total/2)}
+		c.balanced = c.gap <= splitTolerance*total
+		if c.balanced {
+			c.crossRate = crossShardRatio(shard.Transactions, home(offset(g.pos)))
+		}
+		if best == nil || better(c, *best) {
+			best = &c
+		}
+	}
+	if best == nil {
+		return splitPlan{}, fmt.Errorf("%w: shard %d has its load on a single account", ErrUnsplittable, shard.ID)
+	}
+
+	return splitPlan{
+		boundary: best.boundary,
+		offset:   offset,
+		report: SplitReport{
+			HotAccounts: HotAccounts(shard.Transactions, hotKeyCount),
+			CrossShardBefore: crossShardRatio(shard.Transactions, func(account string) int {
+				return sm.ring.Owner(KeyPoint([]byte(account)))
+			}),
+			CrossShardAfter: crossShardRatio(shard.Transactions, home(offset(best.boundary))),
+		},
+	}, nil
+}
@@ -0,0 +1,171 @@
+package amf
+
+import (
+	"blockchain_A3/core"
+	"blockchain_A3/logging"
+	"fmt"
+	"log/slog"
+)
+
+// Height returns the index of the shard's head block, or -1 before the
+// first block is sealed
+func (s *Shard) Height() int {
+	return len(s.Blocks) - 1
+}
+
+// SealBlock appends a block to the shard's chain holding every transaction
+// the chain does not include yet, in shard order. The caller must hold the
+// shard's lock.
+func (s *Shard) SealBlock() *core.Block {
+	if s.Accumulator == nil || s.Accumulator.Size() != len(s.Transactions) {
+		s.RecalculateRootHash()
+	}
+	if s.sealed == nil {
+		s.sealed = make(map[string]int)
+	}
+
+	var batch [][]byte
+	for _, tx := range s.Transactions {
+		if _, ok := s.sealed[string(tx)]; !ok {
+			batch = append(batch, tx)
+		}
+	}
+	block := core.CreateShardBlock(len(s.Blocks), batch, s.tip(), s.RootHash, s.MembershipRoot)
+	s.Blocks = append(s.Blocks, &block)
+	s.Tree = NewMerkleTree(batch)
+	for _, tx := range batch {
+		s.sealed[string(tx)] = block.Index
+	}
+	return &block
+}
+
+// tip returns the hash the shard's next block links to: its head block's,
+// or before the first block, the link to the heads of its parent shards
+func (s *Shard) tip() string {
+	if head := s.Height(); head >= 0 {
+		return s.Blocks[head].Hash
+	}
+	if len(s.ParentHeads) > 0 {
+		return core.LinkHeads(s.ParentHeads)
+	}
+	return ""
+}
+
+// chainHead returns the head a shard split or merged from s links to. A
+// shard with no blocks yet gives height -1 and the link its first block
+// would carry, so the chain still leads back through its own parents.
+func (s *Shard) chainHead() core.ShardHead {
+	if head, err := s.Head(); err == nil {
+		return head
+	}
+	return core.ShardHead{ShardID: s.ID, Height: -1, Hash: s.tip(), RootHash: s.RootHash, MembershipRoot: s.MembershipRoot}
+}
+
+// Head returns the shard's chain head as a beacon chain commits it
+func (s *Shard) Head() (core.ShardHead, error) {
+	head := s.Height()
+	if head < 0 {
+		return core.ShardHead{}, fmt.Errorf("shard %d has no blocks", s.ID)
+	}
+	block := s.Blocks[head]
+	root, membershipRoot, err := core.ParseShardStateRoot(block.StateRoot)
+	if err != nil {
+		return core.ShardHead{}, err
+	}
+	return core.ShardHead{ShardID: s.ID, Height: head, Hash: block.Hash, RootHash: root, MembershipRoot: membershipRoot}, nil
+}
+
+// ProveInclusion proves that tx is in one of the shard's blocks and that
+// the block's chain leads to the head beacon commits for the shard
+func (s *Shard) ProveInclusion(tx []byte, beacon *core.BeaconBlock) (*core.ShardInclusionProof, error) {
+	height, ok := s.sealed[string(tx)]
+	if !ok {
+		return nil, fmt.Errorf("transaction not sealed in shard %d", s.ID)
+	}
+	head, headProof, err := beacon.ProveShard(s.ID)
+	if err != nil {
+		return nil, err
+	}
+	if head.Height < height || head.Height >= len(s.Blocks) || s.Blocks[head.Height].Hash != head.Hash {
+		return nil, fmt.Errorf("beacon block %d does not commit shard %d block %d", beacon.Index, s.ID, height)
+	}
+	txProof, err := core.ProvePayload(s.Blocks[height], tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The block hash does not cover Data, so headers travel without payloads
+	headers := make([]*core.Block, 0, head.Height-height+1)
+	for _, b := range s.Blocks[height : head.Height+1] {
+		header := *b
+		header.Data = nil
+		headers = append(headers, &header)
+	}
+	return &core.ShardInclusionProof{
+		Payload:   tx,
+		TxProof:   txProof,
+		Headers:   headers,
+		Head:      head,
+		HeadProof: headProof,
+	}, nil
+}
+
+// Beacon returns the beacon chain committing the manager's shards
+func (sm *ShardManager) Beacon() *core.BeaconChain {
+	return sm.beacon
+}
+
+// CommitBeacon seals a block on every shard chain and commits all shard
+// heads to the beacon chain. Commits run one at a time, so each beacon block
+// commits heads at least as high as the one before.
+func (sm *ShardManager) CommitBeacon() (*core.BeaconBlock, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	sm.commitMu.Lock()
+	defer sm.commitMu.Unlock()
+
+	heads := make([]core.ShardHead, 0, len(sm.Shards))
+	for _, shard := range sm.Shards {
+		shard.mu.Lock()
+		shard.SealBlock()
+		head, err := shard.Head()
+		shard.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		heads = append(heads, head)
+	}
+	block, err := sm.beacon.Commit(heads)
+	if err != nil {
+		return nil, err
+	}
+	sm.Logger().Info("committed shard heads to beacon chain",
+		slog.Int("beacon_height", block.Index), slog.Int("shards", len(heads)), slog.String("shard_root", block.ShardRoot))
+	return block, nil
+}
+
+// ProveTransaction proves that tx is in a block of the shard holding it,
+// against the latest beacon block committing that shard
+func (sm *ShardManager) ProveTransaction(tx []byte) (*core.ShardInclusionProof, *core.BeaconBlock, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	shard := sm.locate(tx)
+	if shard == nil {
+		return nil, nil, fmt.Errorf("no shard holds transaction")
+	}
+	beacon, err := sm.beacon.LatestCommit(shard.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	proof, err := shard.ProveInclusion(tx, beacon)
+	if err != nil {
+		return nil, nil, err
+	}
+	sm.Logger().Debug("proved shard transaction", logging.Shard(shard.ID), logging.TxHash(tx),
+		slog.Int("shard_height", proof.Headers[0].Index), slog.Int("beacon_height", beacon.Index))
+	return proof, beacon, nil
+}
@@ -1,91 +1,31 @@
 package amf
 
 import (
+	"blockchain_A3/merkle"
 	"blockchain_A3/verification"
-	"crypto/sha256"
 )
 
-// MerkleNode structure
-type MerkleNode struct {
-	Left  *MerkleNode
-	Right *MerkleNode
-	Hash  []byte
-}
-
-// MerkleTree structure
+// MerkleTree is a merkle.MerkleTree over shard transactions
 type MerkleTree struct {
-	Root   *MerkleNode
-	Leaves []*MerkleNode
-}
-
-// NewMerkleNode creates a new Merkle node
-func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
-	node := &MerkleNode{}
-
-	if left == nil && right == nil {
-		hash := sha256.Sum256(data)
-		node.Hash = hash[:]
-	} else {
-		combined := append(left.Hash, right.Hash...)
-		hash := sha256.Sum256(combined)
-		node.Hash = hash[:]
-	}
-
-	node.Left = left
-	node.Right = right
-
-	return node
+	*merkle.MerkleTree
 }
 
 // NewMerkleTree builds a new Merkle Tree
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []*MerkleNode
-
-	for _, datum := range data {
-		node := NewMerkleNode(nil, nil, datum)
-		nodes = append(nodes, node)
-	}
-
-	leaves := nodes
-
-	for len(nodes) > 1 {
-		var newLevel []*MerkleNode
-
-		for i := 0; i < len(nodes); i += 2 {
-			if i+1 == len(nodes) {
-				newLevel = append(newLevel, NewMerkleNode(nodes[i], nodes[i], nil))
-			} else {
-				newLevel = append(newLevel, NewMerkleNode(nodes[i], nodes[i+1], nil))
-			}
-		}
-
-		nodes = newLevel
-	}
-
-	return &MerkleTree{Root: nodes[0], Leaves: leaves}
+	return &MerkleTree{merkle.NewMerkleTree(data)}
 }
 
-// GetTransactionHash hashes a single transaction
+// GetTransactionHash returns the leaf hash of a single transaction
 func GetTransactionHash(data []byte) []byte {
-	hash := sha256.Sum256(data)
-	return hash[:]
+	return merkle.LeafHash(data)
 }
 
+// GenerateMerkleProof proves the leaf at index, returning an empty proof
+// if the index is out of range
 func (tree *MerkleTree) GenerateMerkleProof(index int) *verification.MerkleProof {
-	proof := &verification.MerkleProof{
-		Proof: make([][]byte, 0),
+	steps, err := tree.GenerateProofAt(index)
+	if err != nil {
+		return &verification.MerkleProof{}
 	}
-
-	if index < 0 || index >= len(tree.Leaves) {
-		return proof
-	}
-
-	// Add sibling hashes to the proof
-	for i := 0; i < len(tree.Leaves); i++ {
-		if i != index {
-			proof.Proof = append(proof.Proof, tree.Leaves[i].Hash)
-		}
-	}
-
-	return proof
+	return &verification.MerkleProof{Proof: steps}
 }
@@ -0,0 +1,235 @@
+package amf
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// hotKeyCount is how many of a shard's most frequent keys metrics report
+const hotKeyCount = 5
+
+// KeyCount is how often a placement key was touched within the window
+type KeyCount struct {
+	Key   string
+	Count int
+}
+
+// ShardMetrics is a snapshot of one shard's load
+type ShardMetrics struct {
+	ShardID      int
+	Transactions int
+	Bytes        int
+	// TPS is transactions added or transferred per second over the window
+	TPS float64
+	// CrossShardRatio is the fraction of the window's traffic that was
+	// cross-shard transfers in or out
+	CrossShardRatio float64
+	// Accounts is the number of distinct keys touched within the window
+	Accounts int
+	HotKeys  []KeyCount
+	Score    float64 // load under the policy metric
+}
+
+type metricEvent struct {
+	at    time.Time
+	key   string
+	cross bool
+}
+
+// shardTraffic is one shard's traffic within the window, oldest first
+type shardTraffic struct {
+	events []metricEvent
+	counts map[string]int
+	cross  int
+}
+
+// MetricsCollector tracks per-shard traffic over a sliding window
+type MetricsCollector struct {
+	mu     sync.Mutex
+	window time.Duration
+	now    func() time.Time
+	shards map[int]*shardTraffic
+}
+
+// NewMetricsCollector creates a collector averaging over window, reading
+// the time from now
+func NewMetricsCollector(window time.Duration, now func() time.Time) *MetricsCollector {
+	return &MetricsCollector{window: window, now: now, shards: make(map[int]*shardTraffic)}
+}
+
+func (c *MetricsCollector) traffic(shard int) *shardTraffic {
+	t, ok := c.shards[shard]
+	if !ok {
+		t = &shardTraffic{counts: make(map[string]int)}
+		c.shards[shard] = t
+	}
+	return t
+}
+
+func (t *shardTraffic) add(e metricEvent) {
+	t.events = append(t.events, e)
+	t.counts[e.key]++
+	if e.cross {
+		t.cross++
+	}
+}
+
+// prune drops events older than since
+func (t *shardTraffic) prune(since time.Time) {
+	i := 0
+	for ; i < len(t.events) && !t.events[i].at.After(since); i++ {
+		e := t.events[i]
+		if t.counts[e.key]--; t.counts[e.key] == 0 {
+			delete(t.counts, e.key)
+		}
+		if e.cross {
+			t.cross--
+		}
+	}
+	t.events = t.events[i:]
+}
+
+// Record notes that a shard received traffic for key, cross-shard or not
+func (c *MetricsCollector) Record(shard int, key []byte, cross bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.traffic(shard).add(metricEvent{at: c.now(), key: string(key), cross: cross})
+}
+
+// Move hands the traffic of the keys for which moved reports true from one
+// shard to another, as a split does
+func (c *MetricsCollector) Move(from, to int, moved func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	src := c.traffic(from)
+	kept := &shardTraffic{counts: make(map[string]int)}
+	dst := &shardTraffic{counts: make(map[string]int)}
+	for _, e := range src.events {
+		if moved(e.key) {
+			dst.add(e)
+		} else {
+			kept.add(e)
+		}
+	}
+	c.shards[from] = kept
+	for _, e := range c.traffic(to).events {
+		dst.add(e)
+	}
+	sortEvents(dst.events)
+	c.shards[to] = dst
+}
+
+// Retain drops a shard's traffic for the keys for which keep reports false,
+// as when the shard stops holding them
+func (c *MetricsCollector) Retain(shard int, keep func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := &shardTraffic{counts: make(map[string]int)}
+	for _, e := range c.traffic(shard).events {
+		if keep(e.key) {
+			kept.add(e)
+		}
+	}
+	c.shards[shard] = kept
+}
+
+// Merge hands the traffic of the from shards to into, as a merge does
+func (c *MetricsCollector) Merge(into int, from ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	merged := c.traffic(into)
+	for _, id := range from {
+		for _, e := range c.traffic(id).events {
+			merged.add(e)
+		}
+		delete(c.shards, id)
+	}
+	sortEvents(merged.events)
+}
+
+func sortEvents(events []metricEvent) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+}
+
+// Snapshot returns a shard's traffic metrics over the window. Transactions,
+// Bytes and Score are left for the caller, which holds the shard.
+func (c *MetricsCollector) Snapshot(shard int) ShardMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, t := c.rates(shard)
+	for key, n := range t.counts {
+		m.HotKeys = append(m.HotKeys, KeyCount{Key: key, Count: n})
+	}
+	sort.Slice(m.HotKeys, func(i, j int) bool {
+		if m.HotKeys[i].Count != m.HotKeys[j].Count {
+			return m.HotKeys[i].Count > m.HotKeys[j].Count
+		}
+		return m.HotKeys[i].Key < m.HotKeys[j].Key
+	})
+	if len(m.HotKeys) > hotKeyCount {
+		m.HotKeys = m.HotKeys[:hotKeyCount]
+	}
+	return m
+}
+
+// Rates is Snapshot without HotKeys, cheap enough to run on every update
+func (c *MetricsCollector) Rates(shard int) ShardMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, _ := c.rates(shard)
+	return m
+}
+
+func (c *MetricsCollector) rates(shard int) (ShardMetrics, *shardTraffic) {
+	t := c.traffic(shard)
+	t.prune(c.now().Add(-c.window))
+	m := ShardMetrics{
+		ShardID:  shard,
+		TPS:      float64(len(t.events)) / c.window.Seconds(),
+		Accounts: len(t.counts),
+	}
+	if len(t.events) > 0 {
+		m.CrossShardRatio = float64(t.cross) / float64(len(t.events))
+	}
+	return m, t
+}
+
+// Combined returns the traffic metrics the shards would have if merged
+func (c *MetricsCollector) Combined(shards ...int) ShardMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	merged := &shardTraffic{counts: make(map[string]int)}
+	for _, id := range shards {
+		_, t := c.rates(id)
+		for _, e := range t.events {
+			merged.add(e)
+		}
+	}
+	m := ShardMetrics{
+		TPS:      float64(len(merged.events)) / c.window.Seconds(),
+		Accounts: len(merged.counts),
+	}
+	if len(merged.events) > 0 {
+		m.CrossShardRatio = float64(merged.cross) / float64(len(merged.events))
+	}
+	return m
+}
+
+// KeyTraffic returns how often each key was touched on a shard within the
+// window, and how many of those touches were cross-shard
+func (c *MetricsCollector) KeyTraffic(shard int) (total, cross map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.traffic(shard)
+	t.prune(c.now().Add(-c.window))
+	total = make(map[string]int, len(t.counts))
+	cross = make(map[string]int)
+	for _, e := range t.events {
+		total[e.key]++
+		if e.cross {
+			cross[e.key]++
+		}
+	}
+	return total, cross
+}
@@ -0,0 +1,132 @@
+package amf
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoadMetric selects how a shard's load is measured
+type LoadMetric uint8
+
+const (
+	// MetricTxCount counts the transactions a shard holds
+	MetricTxCount LoadMetric = iota
+	// MetricBytes sums the size of the transactions a shard holds
+	MetricBytes
+	// MetricThroughput is transactions added or transferred per second over
+	// the policy window
+	MetricThroughput
+	// MetricScore weighs several metrics together by the policy's Weights
+	MetricScore
+)
+
+func (m LoadMetric) String() string {
+	switch m {
+	case MetricTxCount:
+		return "tx-count"
+	case MetricBytes:
+		return "bytes"
+	case MetricThroughput:
+		return "throughput"
+	case MetricScore:
+		return "score"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(m))
+	}
+}
+
+// ScoreWeights weighs a shard's metrics into its MetricScore load
+type ScoreWeights struct {
+	Transactions float64 // per transaction held
+	KiB          float64 // per KiB of transactions held
+	TPS          float64 // per transaction per second over the window
+	CrossShard   float64 // per held transaction, scaled by the cross-shard ratio
+	Accounts     float64 // per distinct key touched over the window
+}
+
+// DefaultScoreWeights counts transactions, charging extra for bulky,
+// busy and cross-shard heavy shards
+func DefaultScoreWeights() ScoreWeights {
+	return ScoreWeights{
+		Transactions: 1,
+		KiB:          1,
+		TPS:          1,
+		CrossShard:   1,
+	}
+}
+
+// Score weighs metrics into a single load
+func (w ScoreWeights) Score(m ShardMetrics) float64 {
+	return w.Transactions*float64(m.Transactions) +
+		w.KiB*float64(m.Bytes)/1024 +
+		w.TPS*m.TPS +
+		w.CrossShard*m.CrossShardRatio*float64(m.Transactions) +
+		w.Accounts*float64(m.Accounts)
+}
+
+// ShardPolicy decides when shards are split and merged
+type ShardPolicy struct {
+	MaxLoad float64 // a shard whose load exceeds this is split
+	MinLoad float64 // a shard whose load is below this may be merged
+	// Hysteresis is the fraction of MaxLoad a merged shard must stay below
+	// MaxLoad by, so a merge is not immediately undone by a split
+	Hysteresis float64
+	MaxShards  int           // splits stop at this many shards, zero for no limit
+	Metric     LoadMetric    // how load is measured
+	Window     time.Duration // period traffic metrics are averaged over
+	Weights    ScoreWeights  // how MetricScore weighs metrics
+}
+
+// DefaultShardPolicy splits shards scoring more than 10, which is about 10
+// small transactions with no cross-shard traffic
+func DefaultShardPolicy() ShardPolicy {
+	return ShardPolicy{
+		MaxLoad:    10,
+		MinLoad:    4,
+		Hysteresis: 0.2,
+		MaxShards:  64,
+		Metric:     MetricScore,
+		Window:     time.Minute,
+		Weights:    DefaultScoreWeights(),
+	}
+}
+
+// mergeLimit is the highest combined load a merge may produce
+func (p ShardPolicy) mergeLimit() float64 {
+	return p.MaxLoad * (1 - p.Hysteresis)
+}
+
+// Action is what a rebalancing decision does to the topology
+type Action uint8
+
+const (
+	ActionNone Action = iota
+	ActionSplit
+	ActionMerge
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionNone:
+		return "none"
+	case ActionSplit:
+		return "split"
+	case ActionMerge:
+		return "merge"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(a))
+	}
+}
+
+// Decision records a rebalancing decision and why it was taken
+type Decision struct {
+	Action Action
+	Shards []int     // IDs of the shards acted on
+	Loads  []float64 // their loads under the policy metric
+	Reason string
+	Err    error // why applying the decision failed, if it did
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf("%s %v: %s", d.Action, d.Shards, d.Reason)
+}
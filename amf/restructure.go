@@ -0,0 +1,222 @@
+package amf
+
+import (
+	"blockchain_A3/merkle"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrBadRestructuring = errors.New("restructuring proof is invalid")
+
+// ShardRoot is a shard's transaction root and size on one side of a split
+// or merge
+type ShardRoot struct {
+	ShardID int
+	Root    []byte
+	Size    int
+}
+
+// LeafTransfer carries the leaves one old shard handed to one new shard
+type LeafTransfer struct {
+	From, To int // shard IDs
+	// Proof proves Leaves at Proof.Indices against the old shard's root
+	Proof  *merkle.MultiProof
+	Leaves [][]byte // leaf hashes, in the order of Proof.Indices
+	// NewIndices are the positions of Leaves in the new shard
+	NewIndices []int
+}
+
+// RestructuringProof shows that the shards after a split or merge hold
+// exactly the leaves the shards before held, none lost and none duplicated.
+// The old roots are taken as given; a verifier checks them against the
+// roots it already trusts.
+type RestructuringProof struct {
+	Epoch     uint64
+	Action    Action
+	Old       []ShardRoot
+	New       []ShardRoot
+	Transfers []LeafTransfer
+}
+
+// shardLeaves is a shard's transactions and published root captured on one
+// side of a change
+type shardLeaves struct {
+	id   int
+	root []byte
+	txs  [][]byte
+}
+
+func capture(shards ...*Shard) []shardLeaves {
+	captured := make([]shardLeaves, len(shards))
+	for i, s := range shards {
+		captured[i] = shardLeaves{id: s.ID, root: s.RootHash, txs: append([][]byte(nil), s.Transactions...)}
+	}
+	return captured
+}
+
+// checkPublished verifies that each shard's published root is the root of
+// its transactions, as a restructuring from the shards must be proved against
+func checkPublished(shards ...*Shard) error {
+	for _, s := range shards {
+		if root := merkle.NewMerkleTree(s.Transactions).RootHash(); !bytes.Equal(root, s.RootHash) {
+			return fmt.Errorf("shard %d published root %x, its transactions give %x", s.ID, s.RootHash, root)
+		}
+	}
+	return nil
+}
+
+// proveRestructuring builds the proof that before and after hold the same
+// transactions, and that the roots it proves against are the ones the shards
+// published
+func proveRestructuring(epoch uint64, action Action, before, after []shardLeaves) (*RestructuringProof, error) {
+	p := &RestructuringProof{Epoch: epoch, Action: action}
+	published := func(s shardLeaves, tree *merkle.MerkleTree) error {
+		if !bytes.Equal(tree.RootHash(), s.root) {
+			return fmt.Errorf("shard %d published root %x, its transactions give %x", s.id, s.root, tree.RootHash())
+		}
+		return nil
+	}
+
+	// Where each transaction ended up; a queue per value keeps repeated
+	// values apart
+	type position struct{ shard, index int }
+	dest := make(map[string][]position)
+	for _, s := range after {
+		tree := merkle.NewMerkleTree(s.txs)
+		if err := published(s, tree); err != nil {
+			return nil, err
+		}
+		p.New = append(p.New, ShardRoot{ShardID: s.id, Root: tree.RootHash(), Size: tree.Len()})
+		for i, tx := range s.txs {
+			dest[string(tx)] = append(dest[string(tx)], position{s.id, i})
+		}
+	}
+
+	for _, s := range before {
+		tree := merkle.NewMerkleTree(s.txs)
+		if err := published(s, tree); err != nil {
+			return nil, err
+		}
+		p.Old = append(p.Old, ShardRoot{ShardID: s.id, Root: tree.RootHash(), Size: tree.Len()})
+
+		// Group the old shard's leaves by the new shard they went to
+		byShard := make(map[int][]int)
+		newIndex := make(map[int]int, len(s.txs))
+		var targets []int
+		for i, tx := range s.txs {
+			queue := dest[string(tx)]
+			if len(queue) == 0 {
+				return nil, fmt.Errorf("transaction %d of shard %d is missing after %s", i, s.id, action)
+			}
+			to := queue[0]
+			dest[string(tx)] = queue[1:]
+			if _, ok := byShard[to.shard]; !ok {
+				targets = append(targets, to.shard)
+			}
+			byShard[to.shard] = append(byShard[to.shard], i)
+			newIndex[i] = to.index
+		}
+
+		sort.Ints(targets)
+		for _, to := range targets {
+			indices := byShard[to]
+			proof, err := tree.GenerateMultiProof(indices)
+			if err != nil {
+				return nil, err
+			}
+			t := LeafTransfer{From: s.id, To: to, Proof: proof}
+			for _, i := range indices {
+				t.Leaves = append(t.Leaves, tree.Leaf(i))
+				t.NewIndices = append(t.NewIndices, newIndex[i])
+			}
+			p.Transfers = append(p.Transfers, t)
+		}
+	}
+	for tx, queue := range dest {
+		if len(queue) > 0 {
+			return nil, fmt.Errorf("transaction %x appeared during %s", merkle.LeafHash([]byte(tx)), action)
+		}
+	}
+	return p, nil
+}
+
+// VerifyRestructuring checks that every leaf of the old shards went to
+// exactly one position of one new shard, that every position of the new
+// shards was filled, and that the filled new shards have the claimed roots
+func VerifyRestructuring(p *RestructuringProof) error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrBadRestructuring, fmt.Sprintf(format, args...))
+	}
+
+	old := make(map[int]ShardRoot, len(p.Old))
+	covered := make(map[int][]bool, len(p.Old))
+	for _, s := range p.Old {
+		if _, dup := old[s.ShardID]; dup {
+			return fail("old shard %d listed twice", s.ShardID)
+		}
+		old[s.ShardID] = s
+		covered[s.ShardID] = make([]bool, s.Size)
+	}
+	fresh := make(map[int]ShardRoot, len(p.New))
+	filled := make(map[int][][]byte, len(p.New))
+	for _, s := range p.New {
+		if _, dup := fresh[s.ShardID]; dup {
+			return fail("new shard %d listed twice", s.ShardID)
+		}
+		fresh[s.ShardID] = s
+		filled[s.ShardID] = make([][]byte, s.Size)
+	}
+
+	for _, t := range p.Transfers {
+		from, ok := old[t.From]
+		if !ok {
+			return fail("transfer from unlisted shard %d", t.From)
+		}
+		slots, ok := filled[t.To]
+		if !ok {
+			return fail("transfer to unlisted shard %d", t.To)
+		}
+		if t.Proof == nil || t.Proof.Size != from.Size || len(t.NewIndices) != len(t.Leaves) {
+			return fail("transfer %d -> %d is malformed", t.From, t.To)
+		}
+		if err := merkle.BatchVerify(from.Root, t.Proof, t.Leaves); err != nil {
+			return fail("leaves of shard %d: %v", t.From, err)
+		}
+		for k, i := range t.Proof.Indices {
+			if covered[t.From][i] {
+				return fail("leaf %d of shard %d moved twice", i, t.From)
+			}
+			covered[t.From][i] = true
+			j := t.NewIndices[k]
+			if j < 0 || j >= len(slots) || slots[j] != nil {
+				return fail("position %d of shard %d is out of range or filled twice", j, t.To)
+			}
+			slots[j] = t.Leaves[k]
+		}
+	}
+
+	for _, s := range p.Old {
+		for i, ok := range covered[s.ShardID] {
+			if !ok {
+				return fail("leaf %d of shard %d was lost", i, s.ShardID)
+			}
+		}
+		if s.Size == 0 && !bytes.Equal(s.Root, merkle.EmptyRoot()) {
+			return fail("empty shard %d has a non-empty root", s.ShardID)
+		}
+	}
+	for _, s := range p.New {
+		slots := filled[s.ShardID]
+		for j, leaf := range slots {
+			if leaf == nil {
+				return fail("position %d of shard %d was never filled", j, s.ShardID)
+			}
+		}
+		if !bytes.Equal(merkle.NewMerkleTreeFromHashes(slots).RootHash(), s.Root) {
+			return fail("leaves of shard %d do not give its root", s.ShardID)
+		}
+	}
+	return nil
+}
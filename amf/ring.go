@@ -0,0 +1,140 @@
+package amf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// defaultVirtualNodes is how many ring points each initial shard receives
+const defaultVirtualNodes = 16
+
+// KeyPoint maps a placement key onto the ring
+func KeyPoint(key []byte) uint64 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// HashRing is a consistent-hash ring. Every point owns the arc of ring
+// positions after the previous point up to and including itself, so a key
+// belongs to the shard of the first point at or after its position.
+type HashRing struct {
+	points []ringPoint // sorted by hash
+}
+
+func NewHashRing() *HashRing {
+	return &HashRing{}
+}
+
+// AddShard gives a shard vnodes points spread pseudo-randomly over the ring
+func (r *HashRing) AddShard(shard, vnodes int) {
+	for i := 0; i < vnodes; i++ {
+		r.insert(KeyPoint([]byte(fmt.Sprintf("shard-%d-vnode-%d", shard, i))), shard)
+	}
+}
+
+// insert adds a point owned by shard, reporting false if the position is
+// already taken
+func (r *HashRing) insert(hash uint64, shard int) bool {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i < len(r.points) && r.points[i].hash == hash {
+		return false
+	}
+	r.points = append(r.points, ringPoint{})
+	copy(r.points[i+1:], r.points[i:])
+	r.points[i] = ringPoint{hash: hash, shard: shard}
+	return true
+}
+
+// index returns the index of the point owning position p
+func (r *HashRing) index(p uint64) int {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= p })
+	if i == len(r.points) {
+		return 0
+	}
+	return i
+}
+
+// Owner returns the shard owning ring position p, or -1 on an empty ring
+func (r *HashRing) Owner(p uint64) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+	return r.points[r.index(p)].shard
+}
+
+// Reassign hands every point of one shard to another
+func (r *HashRing) Reassign(from, to int) {
+	for i := range r.points {
+		if r.points[i].shard == from {
+			r.points[i].shard = to
+		}
+	}
+}
+
+// Assign makes shard own the arc ending at position p, adding a point at p
+// if there is none so the arc it used to belong to is cut in two
+func (r *HashRing) Assign(p uint64, shard int) {
+	if !r.insert(p, shard) {
+		r.points[r.index(p)].shard = shard
+	}
+}
+
+// arcStart returns the position just before one of shard's arcs
+func (r *HashRing) arcStart(shard int) uint64 {
+	for i, p := range r.points {
+		if p.shard == shard {
+			return r.points[(i+len(r.points)-1)%len(r.points)].hash
+		}
+	}
+	return 0
+}
+
+// reassignBefore hands to every point of from whose offset is below limit
+func (r *HashRing) reassignBefore(from, to int, offset func(uint64) uint64, limit uint64) {
+	for i := range r.points {
+		if r.points[i].shard == from && offset(r.points[i].hash) < limit {
+			r.points[i].shard = to
+		}
+	}
+}
+
+// Points returns the number of points a shard owns
+func (r *HashRing) Points(shard int) int {
+	n := 0
+	for _, p := range r.points {
+		if p.shard == shard {
+			n++
+		}
+	}
+	return n
+}
+
+// KeyRange is the arc (Start, End] of ring positions owned by one point;
+// Start > End means the arc wraps past zero
+type KeyRange struct {
+	Start, End uint64
+}
+
+func (kr KeyRange) String() string {
+	return fmt.Sprintf("(%016x, %016x]", kr.Start, kr.End)
+}
+
+// Ranges returns the arcs owned by a shard in ring order
+func (r *HashRing) Ranges(shard int) []KeyRange {
+	var ranges []KeyRange
+	for i, p := range r.points {
+		if p.shard != shard {
+			continue
+		}
+		prev := r.points[(i+len(r.points)-1)%len(r.points)].hash
+		ranges = append(ranges, KeyRange{Start: prev, End: p.hash})
+	}
+	return ranges
+}
@@ -2,27 +2,81 @@ package amf
 
 import (
 	"blockchain_A3/core"
+	"blockchain_A3/logging"
 	"blockchain_A3/merkle"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const maxShardLoad = 10
-
 type Shard struct {
+	// ID is stable for the life of the shard, unlike its index in Shards
+	ID int
+	// Tree is the merkle tree over the transactions of the head block
 	Tree         *MerkleTree
 	Load         int
 	Blocks       []*core.Block
 	Transactions [][]byte
 	RootHash     []byte
 	States       [][]byte
+	// Accumulator commits to Transactions in order; RootHash is its root
+	Accumulator *merkle.Accumulator
+	// Membership holds every transaction keyed by merkle.KeyFor, so the
+	// shard can prove a transaction is absent as well as present;
+	// MembershipRoot is its root, published and committed with RootHash
+	Membership     *merkle.SparseMerkleTree
+	MembershipRoot []byte
+	// ParentHeads are the chain heads of the shards this one was split or
+	// merged from, which its first block links to
+	ParentHeads []core.ShardHead
+	positions   map[string]int
+	// sealed maps every transaction in Blocks to the height holding it
+	sealed map[string]int
+	// mu guards the fields above; Shard methods expect the caller to hold it
+	mu sync.RWMutex
 }
 
+// ShardManager is safe for concurrent use. Operations on a shard hold mu for
+// reading plus that shard's lock, taken in ID order when two shards are
+// involved; split and merge hold mu for writing.
 type ShardManager struct {
+	// Shards may only be read directly through WithShards or while no other
+	// goroutine uses the manager
 	Shards []*Shard
-	// Track all transactions to prevent duplicates
-	allTransactions map[string]struct{}
+	mu     sync.RWMutex
+	// ring places transactions by PlacementKey
+	ring *HashRing
+	// placeMu guards owners and history, which shard operations update
+	// concurrently under a read lock on mu
+	placeMu sync.Mutex
+	// owners maps every held transaction to its shard ID, which differs
+	// from the ring owner only after a cross-shard transfer
+	owners map[string]int
+	nextID int
+	policy ShardPolicy
+	// metrics tracks each shard's traffic over the policy window
+	metrics      *MetricsCollector
+	now          func() time.Time
+	lastDecision Decision
+	logger       atomic.Pointer[slog.Logger]
+
+	// epoch counts splits and merges; topologies holds the topology of
+	// every epoch and changes the split or merge that started it
+	epoch      uint64
+	parents    map[int][]int
+	topologies []Topology
+	changes    []TopologyChange
+	// history holds every transaction's placements, guarded by placeMu
+	history map[string][]placement
+	beacon  *core.BeaconChain
+	// commitMu serialises beacon commits, so shard heights never go back;
+	// it is taken under mu and before any shard lock
+	commitMu sync.Mutex
 }
 
 // NewShard creates a new empty shard
@@ -34,124 +88,590 @@ func NewShard() *Shard {
 	}
 }
 
-// NewShardManager initializes a new ShardManager
-func NewShardManager() *ShardManager {
-	return &ShardManager{
-		Shards: []*Shard{
-			{
-				Load:     0,
-				RootHash: []byte("initialRootHash"),
-				States:   [][]byte{},
-			},
-		},
-		allTransactions: make(map[string]struct{}),
+// NewShardManager initializes a new ShardManager with one shard owning the
+// whole ring, splitting and merging shards as policy dictates. A MaxLoad or
+// Window that is not positive, a Hysteresis outside [0, 1), and unset
+// Weights under MetricScore take their DefaultShardPolicy values; a negative
+// MinLoad is taken as zero. Other zero fields keep their meaning: a MinLoad
+// of zero never merges, zero Hysteresis merges up to MaxLoad, zero MaxShards
+// sets no limit and the zero Metric is MetricTxCount, so start from
+// DefaultShardPolicy to change only some fields.
+func NewShardManager(policy ShardPolicy) *ShardManager {
+	def := DefaultShardPolicy()
+	if policy.MaxLoad <= 0 {
+		policy.MaxLoad = def.MaxLoad
+	}
+	policy.MinLoad = max(policy.MinLoad, 0)
+	if policy.Hysteresis < 0 || policy.Hysteresis >= 1 {
+		policy.Hysteresis = def.Hysteresis
+	}
+	if policy.Window <= 0 {
+		policy.Window = def.Window
 	}
+	if policy.Metric == MetricScore && policy.Weights == (ScoreWeights{}) {
+		policy.Weights = def.Weights
+	}
+	sm := &ShardManager{
+		ring:    NewHashRing(),
+		owners:  make(map[string]int),
+		policy:  policy,
+		now:     time.Now,
+		parents: make(map[int][]int),
+		history: make(map[string][]placement),
+		beacon:  core.NewBeaconChain(),
+	}
+	sm.metrics = NewMetricsCollector(policy.Window, sm.now)
+	sm.Shards = append(sm.Shards, sm.newShard())
+	sm.ring.AddShard(sm.Shards[0].ID, defaultVirtualNodes)
+	sm.topologies = append(sm.topologies, sm.snapshot())
+	return sm
 }
 
-// AddTransaction adds a transaction to the appropriate shard
-func (sm *ShardManager) AddTransaction(tx []byte) error {
-	// Check for duplicate transaction
-	if _, exists := sm.allTransactions[string(tx)]; exists {
-		return fmt.Errorf("transaction already exists")
+// PlacementKey returns the key a transaction is placed by on the ring: its
+// sender account, so an account's transactions share a shard, or the
+// transaction itself if it names no accounts
+func PlacementKey(tx []byte) []byte {
+	if sender, _, ok := Accounts(tx); ok {
+		return []byte(sender)
 	}
+	return tx
+}
+
+func (sm *ShardManager) newShard() *Shard {
+	s := NewShard()
+	s.ID = sm.nextID
+	sm.nextID++
+	// publish the empty root rather than a placeholder, so restructuring
+	// proofs can check it
+	s.RecalculateRootHash()
+	return s
+}
+
+// ShardByID returns the shard with the given ID, or nil if it no longer exists
+func (sm *ShardManager) ShardByID(id int) *Shard {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.shardByID(id)
+}
 
-	// Add to all transactions map
-	sm.allTransactions[string(tx)] = struct{}{}
+func (sm *ShardManager) shardByID(id int) *Shard {
+	for _, shard := range sm.Shards {
+		if shard.ID == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+// Locate returns the shard holding tx, or the shard the ring would place it
+// in if it is not held
+func (sm *ShardManager) Locate(tx []byte) *Shard {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.locate(tx)
+}
+
+func (sm *ShardManager) locate(tx []byte) *Shard {
+	sm.placeMu.Lock()
+	id, ok := sm.owners[string(tx)]
+	sm.placeMu.Unlock()
+	if ok {
+		return sm.shardByID(id)
+	}
+	return sm.shardByID(sm.ring.Owner(KeyPoint(PlacementKey(tx))))
+}
 
+// Relocate records that tx was moved to shard to outside the ring, as a
+// cross-shard transfer does, so Locate keeps finding it
+func (sm *ShardManager) Relocate(tx []byte, to *Shard) {
+	sm.placeMu.Lock()
+	defer sm.placeMu.Unlock()
+	if from, ok := sm.owners[string(tx)]; ok && from != to.ID {
+		sm.metrics.Record(from, PlacementKey(tx), true)
+	}
+	sm.metrics.Record(to.ID, PlacementKey(tx), true)
+	sm.owners[string(tx)] = to.ID
+	sm.placed(tx, to.ID)
+}
+
+// WithShards calls fn with the current shards while holding the topology
+// steady, so no split or merge runs until fn returns. fn must lock any shard
+// it reads or modifies, using LockShards for more than one, and must not
+// call other ShardManager methods that take the topology lock.
+func (sm *ShardManager) WithShards(fn func(shards []*Shard) error) error {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return fn(sm.Shards)
+}
+
+// LockShards write-locks the given shards in ID order, so two operations
+// locking the same pair cannot deadlock, and returns a function unlocking
+// them. A shard passed twice is locked once.
+func LockShards(shards ...*Shard) (unlock func()) {
+	ordered := append([]*Shard(nil), shards...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].ID < ordered[j].ID })
+	locked := ordered[:0]
+	for i, shard := range ordered {
+		if i > 0 && shard == ordered[i-1] {
+			continue
+		}
+		shard.mu.Lock()
+		locked = append(locked, shard)
+	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+	}
+}
+
+// Policy returns the policy the manager rebalances by
+func (sm *ShardManager) Policy() ShardPolicy {
+	return sm.policy
+}
+
+// LastDecision returns the most recent rebalancing decision and its reason
+func (sm *ShardManager) LastDecision() Decision {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastDecision
+}
+
+// SetLogger sets the logger shard operations report to; nil silences it
+func (sm *ShardManager) SetLogger(l *slog.Logger) {
+	sm.logger.Store(logging.OrDiscard(l))
+}
+
+// Logger returns the manager's logger, for packages operating on its shards
+func (sm *ShardManager) Logger() *slog.Logger {
+	return logging.OrDiscard(sm.logger.Load())
+}
+
+// AddTransaction adds a transaction to the appropriate shard. Only the
+// target shard is locked unless the addition calls for a rebalance. Once
+// placed the transaction stays added: a failed rebalance is logged and
+// recorded in LastDecision rather than returned.
+func (sm *ShardManager) AddTransaction(tx []byte) error {
 	// If no shards exist, create the first one
-	if len(sm.Shards) == 0 {
-		sm.Shards = append(sm.Shards, NewShard())
+	sm.mu.RLock()
+	empty := len(sm.Shards) == 0
+	sm.mu.RUnlock()
+	if empty {
+		sm.mu.Lock()
+		if len(sm.Shards) == 0 {
+			sm.beginEpoch()
+			sm.Shards = append(sm.Shards, sm.newShard())
+			sm.ring = NewHashRing()
+			sm.ring.AddShard(sm.Shards[0].ID, defaultVirtualNodes)
+			sm.commitEpoch(TopologyChange{Children: []int{sm.Shards[0].ID}, Reason: "no shards left"})
+		}
+		sm.mu.Unlock()
+	}
+
+	overloaded, err := sm.place(tx)
+	if err != nil || !overloaded {
+		return err
 	}
 
-	// Find shard with lowest load
-	lowestLoadShard := sm.Shards[0]
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if err := sm.rebalance(); err != nil {
+		sm.lastDecision = Decision{Reason: fmt.Sprintf("rebalance after placement failed: %v", err), Err: err}
+		sm.Logger().Warn("rebalance after placement failed", slog.Any("error", err))
+	}
+	return nil
+}
+
+// place appends tx to the shard owning it on the ring and reports whether
+// that shard is now over the policy's max load
+func (sm *ShardManager) place(tx []byte) (bool, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	shard := sm.locate(tx)
+	if shard == nil {
+		return false, fmt.Errorf("no shard owns transaction")
+	}
+
+	// Check for duplicate transaction, reserving tx so a concurrent add of
+	// the same transaction fails
+	sm.placeMu.Lock()
+	if _, exists := sm.owners[string(tx)]; exists {
+		sm.placeMu.Unlock()
+		return false, fmt.Errorf("transaction already exists")
+	}
+	sm.owners[string(tx)] = shard.ID
+	sm.placed(tx, shard.ID)
+	sm.placeMu.Unlock()
+	sm.metrics.Record(shard.ID, PlacementKey(tx), false)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.AppendTransaction(tx)
+	return sm.load(shard) > sm.policy.MaxLoad, nil
+}
+
+// ShardLoad measures a shard under the policy metric
+func (sm *ShardManager) ShardLoad(shard *Shard) float64 {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return sm.load(shard)
+}
+
+// load measures a shard; the caller must hold the shard's lock or mu for writing
+func (sm *ShardManager) load(shard *Shard) float64 {
+	switch sm.policy.Metric {
+	case MetricTxCount:
+		return float64(shard.Load)
+	case MetricBytes:
+		return float64(shardBytes(shard))
+	default:
+		return sm.measure(sm.metrics.Rates(shard.ID), shard)
+	}
+}
+
+// measure completes traffic metrics with what the shard holds and returns
+// the load under the policy metric
+func (sm *ShardManager) measure(m ShardMetrics, shard *Shard) float64 {
+	m.Transactions = shard.Load
+	m.Bytes = shardBytes(shard)
+	return sm.score(m)
+}
+
+// score returns the load of complete metrics under the policy metric
+func (sm *ShardManager) score(m ShardMetrics) float64 {
+	switch sm.policy.Metric {
+	case MetricTxCount:
+		return float64(m.Transactions)
+	case MetricBytes:
+		return float64(m.Bytes)
+	case MetricThroughput:
+		return m.TPS
+	default:
+		return sm.policy.Weights.Score(m)
+	}
+}
+
+func shardBytes(shard *Shard) int {
+	n := 0
+	for _, tx := range shard.Transactions {
+		n += len(tx)
+	}
+	return n
+}
+
+// Metrics returns a snapshot of every shard's metrics, Score being its load
+// under the policy metric
+func (sm *ShardManager) Metrics() []ShardMetrics {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	metrics := make([]ShardMetrics, 0, len(sm.Shards))
 	for _, shard := range sm.Shards {
-		if shard.Load < lowestLoadShard.Load {
-			lowestLoadShard = shard
+		shard.mu.RLock()
+		m := sm.metrics.Snapshot(shard.ID)
+		m.Score = sm.measure(m, shard)
+		m.Transactions = shard.Load
+		m.Bytes = shardBytes(shard)
+		shard.mu.RUnlock()
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// keyLoads returns each held transaction's share of the shard's load under
+// the policy metric, so a split can divide the load rather than the count
+func (sm *ShardManager) keyLoads(shard *Shard) map[string]float64 {
+	traffic, cross := sm.metrics.KeyTraffic(shard.ID)
+	m := sm.metrics.Rates(shard.ID)
+	secs := sm.policy.Window.Seconds()
+	w := sm.policy.Weights
+
+	// an account's traffic is shared among the transactions it placed here
+	held := make(map[string]int)
+	for _, tx := range shard.Transactions {
+		held[string(PlacementKey(tx))]++
+	}
+
+	loads := make(map[string]float64, len(shard.Transactions))
+	for _, tx := range shard.Transactions {
+		key := string(PlacementKey(tx))
+		share := 1 / float64(held[key])
+		var load float64
+		switch sm.policy.Metric {
+		case MetricTxCount:
+			load = 1
+		case MetricBytes:
+			load = float64(len(tx))
+		case MetricThroughput:
+			load = float64(traffic[key]) / secs * share
+		default:
+			load = w.Transactions + w.KiB*float64(len(tx))/1024 + w.TPS*float64(traffic[key])/secs*share
+			// the cross-shard charge falls on the keys that crossed shards
+			if m.CrossShardRatio > 0 && cross[key] > 0 {
+				load += w.CrossShard * float64(cross[key]) * m.CrossShardRatio * float64(shard.Load) / float64(crossTotal(cross)) * share
+			}
+			if traffic[key] > 0 {
+				load += w.Accounts * share
+			}
 		}
+		loads[string(tx)] = load
+	}
+	return loads
+}
+
+func crossTotal(cross map[string]int) int {
+	n := 0
+	for _, c := range cross {
+		n += c
+	}
+	return n
+}
+
+// Evaluate decides what the policy would do to the current topology
+// without doing it
+func (sm *ShardManager) Evaluate() Decision {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, split := sm.splitCandidate(nil)
+	if split.Action == ActionSplit {
+		return split
+	}
+	_, merge := sm.mergeCandidate()
+	if merge.Action == ActionMerge {
+		return merge
 	}
+	return Decision{Reason: split.Reason + "; " + merge.Reason}
+}
+
+// Rebalance splits and merges shards until the policy is satisfied. A merge
+// only goes ahead if the merged shard scores within the merge limit, so it
+// is not split straight back; the number of steps is bounded regardless,
+// since a score is not additive across shards.
+func (sm *ShardManager) Rebalance() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.rebalance()
+}
 
-	// Add transaction to shard with lowest load
-	lowestLoadShard.Transactions = append(lowestLoadShard.Transactions, tx)
-	lowestLoadShard.States = append(lowestLoadShard.States, tx)
-	lowestLoadShard.Load = len(lowestLoadShard.Transactions)
-	lowestLoadShard.RecalculateRootHash()
+// maxRebalanceSteps bounds the splits and merges of one rebalance
+const maxRebalanceSteps = 256
 
-	// Check if split is needed
-	if lowestLoadShard.Load > maxShardLoad {
-		return sm.SplitShard()
+func (sm *ShardManager) rebalance() error {
+	unsplittable := make(map[int]bool)
+	for step := 0; ; step++ {
+		if step == maxRebalanceSteps {
+			return fmt.Errorf("rebalance did not settle within %d steps", maxRebalanceSteps)
+		}
+		if shard, d := sm.splitCandidate(unsplittable); d.Action == ActionSplit {
+			if err := sm.split(shard, d); errors.Is(err, ErrUnsplittable) {
+				// a single hot account outgrew the policy; leave it be and
+				// carry on with the other shards
+				unsplittable[shard.ID] = true
+				d.Action = ActionNone
+				d.Reason = fmt.Sprintf("%s but %v", d.Reason, err)
+				sm.record(d)
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		if pair, d := sm.mergeCandidate(); d.Action == ActionMerge {
+			if err := sm.merge(pair, d); err != nil {
+				return err
+			}
+			continue
+		}
+		return nil
 	}
+}
 
-	return nil
+// record keeps and logs a decision that is about to be applied
+func (sm *ShardManager) record(d Decision) {
+	sm.lastDecision = d
+	sm.Logger().Info("rebalance decision",
+		slog.String("action", d.Action.String()), slog.Any("shards", d.Shards), slog.String("reason", d.Reason))
 }
 
-// Helper to get leaves as data
-func (mt *MerkleTree) LeavesData() [][]byte {
-	var data [][]byte
-	for _, leaf := range mt.Leaves {
-		data = append(data, leaf.Hash)
+// splitCandidate finds the most loaded shard not in skip and whether the
+// policy splits it
+func (sm *ShardManager) splitCandidate(skip map[int]bool) (*Shard, Decision) {
+	var highest *Shard
+	var highestLoad float64
+	for _, shard := range sm.Shards {
+		if skip[shard.ID] {
+			continue
+		}
+		if load := sm.load(shard); highest == nil || load > highestLoad {
+			highest, highestLoad = shard, load
+		}
+	}
+	if highest == nil {
+		return nil, Decision{Reason: "no shards to split"}
+	}
+
+	p := sm.policy
+	d := Decision{Shards: []int{highest.ID}, Loads: []float64{highestLoad}}
+	switch {
+	case highestLoad <= p.MaxLoad:
+		d.Reason = fmt.Sprintf("highest %s load %.2f is within max %.2f", p.Metric, highestLoad, p.MaxLoad)
+	case p.MaxShards > 0 && len(sm.Shards) >= p.MaxShards:
+		d.Reason = fmt.Sprintf("%s load %.2f exceeds max %.2f but shard count is at limit %d", p.Metric, highestLoad, p.MaxLoad, p.MaxShards)
+	default:
+		d.Action = ActionSplit
+		d.Reason = fmt.Sprintf("%s load %.2f exceeds max %.2f", p.Metric, highestLoad, p.MaxLoad)
 	}
-	return data
+	return highest, d
 }
 
-// SplitShard splits the shard with highest load
-func (sm *ShardManager) SplitShard() error {
-	// Find shard with highest load
-	var highestLoadShard *Shard
-	maxLoad := 0
+// mergeCandidate finds the two least loaded shards and whether the policy
+// merges them
+func (sm *ShardManager) mergeCandidate() ([2]*Shard, Decision) {
+	var lowest [2]*Shard
+	if len(sm.Shards) < 2 {
+		return lowest, Decision{Reason: "fewer than two shards to merge"}
+	}
 
+	var loads [2]float64
 	for _, shard := range sm.Shards {
-		if shard.Load > maxLoad {
-			maxLoad = shard.Load
-			highestLoadShard = shard
+		load := sm.load(shard)
+		switch {
+		case lowest[0] == nil || load < loads[0]:
+			lowest[1], loads[1] = lowest[0], loads[0]
+			lowest[0], loads[0] = shard, load
+		case lowest[1] == nil || load < loads[1]:
+			lowest[1], loads[1] = shard, load
 		}
 	}
 
-	if highestLoadShard == nil {
-		return fmt.Errorf("no shards available to split")
+	p := sm.policy
+	combined := sm.mergedLoad(lowest)
+	d := Decision{Shards: []int{lowest[0].ID, lowest[1].ID}, Loads: loads[:]}
+	switch {
+	case loads[0] >= p.MinLoad:
+		d.Reason = fmt.Sprintf("lowest %s load %.2f is not below min %.2f", p.Metric, loads[0], p.MinLoad)
+	case combined > p.mergeLimit():
+		d.Reason = fmt.Sprintf("combined %s load %.2f would exceed %.2f (max %.2f less %.0f%% hysteresis)",
+			p.Metric, combined, p.mergeLimit(), p.MaxLoad, p.Hysteresis*100)
+	default:
+		d.Action = ActionMerge
+		d.Reason = fmt.Sprintf("%s load %.2f is below min %.2f and merging gives %.2f within %.2f",
+			p.Metric, loads[0], p.MinLoad, combined, p.mergeLimit())
+	}
+	return lowest, d
+}
+
+// mergedLoad returns the load the shards would have once merged
+func (sm *ShardManager) mergedLoad(pair [2]*Shard) float64 {
+	switch sm.policy.Metric {
+	case MetricTxCount, MetricBytes:
+		return sm.load(pair[0]) + sm.load(pair[1])
 	}
+	m := sm.metrics.Combined(pair[0].ID, pair[1].ID)
+	m.Transactions = pair[0].Load + pair[1].Load
+	m.Bytes = shardBytes(pair[0]) + shardBytes(pair[1])
+	return sm.score(m)
+}
+
+// Helper to get leaves as data
+func (mt *MerkleTree) LeavesData() [][]byte {
+	return mt.Leaves()
+}
 
-	if highestLoadShard.Load < maxShardLoad {
-		return fmt.Errorf("shard load %d is below threshold %d", highestLoadShard.Load, maxShardLoad)
+// SplitShard splits the shard with highest load if the policy calls for it
+func (sm *ShardManager) SplitShard() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	shard, d := sm.splitCandidate(nil)
+	if d.Action != ActionSplit {
+		return fmt.Errorf("no shard to split: %s", d.Reason)
 	}
+	return sm.split(shard, d)
+}
+
+func (sm *ShardManager) split(shard *Shard, d Decision) error {
+	sm.record(d)
+	return sm.splitShard(shard, d.Reason)
+}
 
-	// Create new shard
-	newShard := NewShard()
+// splitShard hands a new shard the ring ranges covering about half of the
+// accounts placed in shard, so only those accounts move
+func (sm *ShardManager) splitShard(shard *Shard, reason string) error {
+	plan, err := sm.planSplit(shard)
+	if err != nil {
+		return err
+	}
+	if err := checkPublished(shard); err != nil {
+		return fmt.Errorf("cannot split: %w", err)
+	}
 
-	// Move half of transactions to new shard
-	mid := len(highestLoadShard.Transactions) / 2
-	newShard.Transactions = append(newShard.Transactions, highestLoadShard.Transactions[mid:]...)
-	newShard.States = append(newShard.States, highestLoadShard.States[mid:]...)
+	before := capture(shard)
+	sm.beginEpoch()
+	newShard := sm.newShard()
+	sm.parents[newShard.ID] = []int{shard.ID}
+	newShard.ParentHeads = []core.ShardHead{shard.chainHead()}
+	sm.ring.reassignBefore(shard.ID, newShard.ID, plan.offset, plan.offset(plan.boundary))
+	sm.ring.Assign(plan.boundary, newShard.ID)
+	sm.metrics.Move(shard.ID, newShard.ID, func(key string) bool {
+		return sm.ring.Owner(KeyPoint([]byte(key))) == newShard.ID
+	})
 
-	// Update original shard
-	highestLoadShard.Transactions = highestLoadShard.Transactions[:mid]
-	highestLoadShard.States = highestLoadShard.States[:mid]
+	// Move exactly the keys whose ring owner changed
+	sm.placeMu.Lock()
+	defer sm.placeMu.Unlock()
+	kept := shard.Transactions[:0:0]
+	for _, tx := range shard.Transactions {
+		if sm.owners[string(tx)] == shard.ID && sm.ring.Owner(KeyPoint(PlacementKey(tx))) == newShard.ID {
+			newShard.Transactions = append(newShard.Transactions, tx)
+			newShard.States = append(newShard.States, tx)
+			sm.owners[string(tx)] = newShard.ID
+			sm.placed(tx, newShard.ID)
+		} else {
+			kept = append(kept, tx)
+		}
+	}
+	shard.Transactions = kept
+	shard.States = append([][]byte(nil), kept...)
 
 	// Update loads and root hashes
-	highestLoadShard.Load = len(highestLoadShard.Transactions)
+	shard.Load = len(shard.Transactions)
 	newShard.Load = len(newShard.Transactions)
-	highestLoadShard.RecalculateRootHash()
+	shard.RecalculateRootHash()
 	newShard.RecalculateRootHash()
 
 	// Add new shard to manager
 	sm.Shards = append(sm.Shards, newShard)
+	sm.commitEpoch(TopologyChange{
+		Action:   ActionSplit,
+		Parents:  []int{shard.ID},
+		Children: []int{shard.ID, newShard.ID},
+		Moved:    newShard.Load,
+		Reason:   reason,
+		Split:    &plan.report,
+	}, before...)
+	sm.Logger().Info("split shard", slog.Uint64("epoch", sm.epoch),
+		logging.Shard(shard.ID), logging.Root(shard.RootHash), slog.Int("load", shard.Load),
+		slog.Int("new_shard", newShard.ID), slog.Int("new_load", newShard.Load),
+		slog.Float64("cross_before", plan.report.CrossShardBefore), slog.Float64("cross_after", plan.report.CrossShardAfter))
 
 	return nil
 }
 
-// ShouldSplit checks if any shard needs splitting
+// ShouldSplit checks if the policy calls for a shard to be split
 func (sm *ShardManager) ShouldSplit() bool {
-	for _, shard := range sm.Shards {
-		if shard.Load >= maxShardLoad {
-			fmt.Printf("Shard with load %d needs splitting (threshold: %d)\n", shard.Load, maxShardLoad)
-			return true
-		}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, d := sm.splitCandidate(nil)
+	if d.Action == ActionSplit {
+		sm.Logger().Info("shard needs splitting", logging.Shard(d.Shards[0]), slog.String("reason", d.Reason))
+		return true
 	}
 	return false
 }
 
 // PrintShards prints the current state of all shards
 func (manager *ShardManager) PrintShards() {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
 	if len(manager.Shards) == 0 {
 		fmt.Println("No shards available.")
 		return
@@ -160,9 +680,12 @@ func (manager *ShardManager) PrintShards() {
 	fmt.Println("\nShard Status Report:")
 	fmt.Println("===================")
 	for i, shard := range manager.Shards {
+		shard.mu.RLock()
 		fmt.Printf("\nShard %d Status:\n", i)
 		fmt.Printf("-----------------\n")
-		fmt.Printf("Current Load: %d/%d transactions\n", shard.Load, maxShardLoad)
+		fmt.Printf("Shard ID: %d (%d ring ranges)\n", shard.ID, manager.ring.Points(shard.ID))
+		load := manager.load(shard)
+		fmt.Printf("Current Load: %d transactions (%s %.2f/%.2f)\n", shard.Load, manager.policy.Metric, load, manager.policy.MaxLoad)
 		fmt.Printf("Root Hash: %x\n", shard.RootHash)
 
 		if shard.Load == 0 {
@@ -175,147 +698,199 @@ func (manager *ShardManager) PrintShards() {
 		}
 
 		// Show shard condition
-		if shard.Load >= maxShardLoad {
+		if load > manager.policy.MaxLoad {
 			fmt.Println("Condition: Overloaded - Needs splitting")
 		} else if shard.Load == 0 {
 			fmt.Println("Condition: Empty")
 		} else {
-			fmt.Printf("Condition: Normal (%.1f%% capacity)\n", float64(shard.Load)/float64(maxShardLoad)*100)
+			fmt.Printf("Condition: Normal (%.1f%% capacity)\n", load/manager.policy.MaxLoad*100)
 		}
 		fmt.Println("-----------------")
+		shard.mu.RUnlock()
 	}
 	fmt.Println("===================")
 }
 
 // GetShard retrieves a shard by index
 func (manager *ShardManager) GetShard(index int) *Shard {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
 	if index >= 0 && index < len(manager.Shards) {
 		return manager.Shards[index]
 	}
 	return nil
 }
-func (sm *ShardManager) ShouldMerge() bool {
-	if len(sm.Shards) < 2 {
-		return false
-	}
-
-	// Find two shards with lowest load
-	var lowestLoadShards [2]*Shard
-	lowestLoadShards[0] = sm.Shards[0]
-	lowestLoadShards[1] = sm.Shards[1]
 
-	for _, shard := range sm.Shards {
-		if shard.Load < lowestLoadShards[0].Load {
-			lowestLoadShards[1] = lowestLoadShards[0]
-			lowestLoadShards[0] = shard
-		} else if shard.Load < lowestLoadShards[1].Load {
-			lowestLoadShards[1] = shard
-		}
-	}
-
-	// Check if merge is needed (if combined load is below threshold)
-	combinedLoad := lowestLoadShards[0].Load + lowestLoadShards[1].Load
-	if combinedLoad <= maxShardLoad {
-		fmt.Printf("\nMerge possible: Shards with loads %d and %d (combined: %d, threshold: %d)\n",
-			lowestLoadShards[0].Load, lowestLoadShards[1].Load, combinedLoad, maxShardLoad)
+// ShouldMerge checks if the policy calls for two shards to be merged
+func (sm *ShardManager) ShouldMerge() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, d := sm.mergeCandidate()
+	if d.Action == ActionMerge {
+		sm.Logger().Info("merge possible", slog.Any("shards", d.Shards), slog.String("reason", d.Reason))
 		return true
 	}
 	return false
 }
 
+// MergeShards merges the two least loaded shards if the policy calls for it
 func (sm *ShardManager) MergeShards() error {
-	if len(sm.Shards) < 2 {
-		return fmt.Errorf("not enough shards to merge")
-	}
-
-	// Find two shards with lowest load
-	var lowestLoadShards [2]*Shard
-	var lowestLoadIndices [2]int
-	lowestLoadShards[0] = sm.Shards[0]
-	lowestLoadShards[1] = sm.Shards[1]
-
-	for i, shard := range sm.Shards {
-		if shard.Load < lowestLoadShards[0].Load {
-			lowestLoadShards[1] = lowestLoadShards[0]
-			lowestLoadIndices[1] = lowestLoadIndices[0]
-			lowestLoadShards[0] = shard
-			lowestLoadIndices[0] = i
-		} else if shard.Load < lowestLoadShards[1].Load {
-			lowestLoadShards[1] = shard
-			lowestLoadIndices[1] = i
-		}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	pair, d := sm.mergeCandidate()
+	if d.Action != ActionMerge {
+		return fmt.Errorf("no shards to merge: %s", d.Reason)
 	}
+	return sm.merge(pair, d)
+}
 
-	// Check if merge is needed
-	combinedLoad := lowestLoadShards[0].Load + lowestLoadShards[1].Load
-	if combinedLoad > maxShardLoad {
-		return fmt.Errorf("combined load %d exceeds threshold %d", combinedLoad, maxShardLoad)
+// merge replaces two shards with a new one owning both shards' ring ranges
+func (sm *ShardManager) merge(pair [2]*Shard, d Decision) error {
+	if err := checkPublished(pair[0], pair[1]); err != nil {
+		return fmt.Errorf("cannot merge: %w", err)
 	}
-
-	fmt.Printf("\nMerging shards with loads %d and %d\n", lowestLoadShards[0].Load, lowestLoadShards[1].Load)
-
-	// Create merged shard
-	mergedShard := &Shard{
-		Transactions: append(lowestLoadShards[0].Transactions, lowestLoadShards[1].Transactions...),
-		States:       append(lowestLoadShards[0].States, lowestLoadShards[1].States...),
-		Load:         combinedLoad,
+	sm.record(d)
+	sm.Logger().Info("merging shards",
+		logging.Shard(pair[0].ID), slog.Int("other_shard", pair[1].ID),
+		slog.Int("load_a", pair[0].Load), slog.Int("load_b", pair[1].Load))
+
+	before := capture(pair[0], pair[1])
+	sm.beginEpoch()
+	mergedShard := sm.newShard()
+	sm.parents[mergedShard.ID] = []int{pair[0].ID, pair[1].ID}
+	mergedShard.ParentHeads = []core.ShardHead{pair[0].chainHead(), pair[1].chainHead()}
+	for _, shard := range pair {
+		sm.ring.Reassign(shard.ID, mergedShard.ID)
+		sm.placeMu.Lock()
+		for _, tx := range shard.Transactions {
+			sm.owners[string(tx)] = mergedShard.ID
+			sm.placed(tx, mergedShard.ID)
+		}
+		sm.placeMu.Unlock()
+		mergedShard.Transactions = append(mergedShard.Transactions, shard.Transactions...)
+		mergedShard.States = append(mergedShard.States, shard.States...)
 	}
+	mergedShard.Load = len(mergedShard.Transactions)
 	mergedShard.RecalculateRootHash()
+	sm.metrics.Merge(mergedShard.ID, pair[0].ID, pair[1].ID)
 
 	// Remove the two merged shards and add the new one
-	sm.Shards = append(sm.Shards[:lowestLoadIndices[0]], sm.Shards[lowestLoadIndices[0]+1:]...)
-	if lowestLoadIndices[1] > lowestLoadIndices[0] {
-		lowestLoadIndices[1]--
+	shards := sm.Shards[:0:0]
+	for _, shard := range sm.Shards {
+		if shard != pair[0] && shard != pair[1] {
+			shards = append(shards, shard)
+		}
 	}
-	sm.Shards = append(sm.Shards[:lowestLoadIndices[1]], sm.Shards[lowestLoadIndices[1]+1:]...)
-	sm.Shards = append(sm.Shards, mergedShard)
+	sm.Shards = append(shards, mergedShard)
+	sm.commitEpoch(TopologyChange{
+		Action:   ActionMerge,
+		Parents:  []int{pair[0].ID, pair[1].ID},
+		Children: []int{mergedShard.ID},
+		Moved:    mergedShard.Load,
+		Reason:   d.Reason,
+	}, before...)
+
+	sm.Logger().Info("merge complete", slog.Uint64("epoch", sm.epoch),
+		logging.Shard(mergedShard.ID), slog.Int("load", mergedShard.Load), logging.Root(mergedShard.RootHash))
+	return nil
+}
 
-	fmt.Printf("Merge complete. New shard has %d transactions\n", mergedShard.Load)
+// RecalculateRootHash rebuilds the shard's accumulator from Transactions.
+// It is only needed after transactions are removed or reordered; appends
+// go through AppendTransaction.
+func (s *Shard) RecalculateRootHash() {
+	s.Accumulator = merkle.NewAccumulator()
+	s.Membership = merkle.NewSparseMerkleTree()
+	s.positions = make(map[string]int, len(s.Transactions))
+	for _, tx := range s.Transactions {
+		s.index(tx, s.Accumulator.Append(tx))
+	}
+	s.RootHash = s.Accumulator.Root()
+	s.MembershipRoot = s.Membership.Root()
+}
 
-	// Check if the merged shard needs splitting
-	if mergedShard.Load >= maxShardLoad {
-		fmt.Println("\nMerged shard exceeds threshold, performing split...")
-		return sm.SplitShard()
+// AppendTransaction adds a transaction and updates RootHash in O(log n)
+func (s *Shard) AppendTransaction(tx []byte) {
+	if s.Accumulator == nil || s.Accumulator.Size() != len(s.Transactions) {
+		s.RecalculateRootHash()
 	}
+	s.Transactions = append(s.Transactions, tx)
+	s.States = append(s.States, tx)
+	s.Load = len(s.Transactions)
+	s.index(tx, s.Accumulator.Append(tx))
+	s.RootHash = s.Accumulator.Root()
+	s.MembershipRoot = s.Membership.Root()
+}
 
+// RemoveTransaction removes the transaction at index and rebuilds the root
+func (s *Shard) RemoveTransaction(index int) error {
+	if index < 0 || index >= len(s.Transactions) {
+		return fmt.Errorf("invalid transaction index: %d", index)
+	}
+	s.Transactions = append(s.Transactions[:index:index], s.Transactions[index+1:]...)
+	s.States = append(s.States[:index:index], s.States[index+1:]...)
+	s.Load = len(s.Transactions)
+	s.RecalculateRootHash()
 	return nil
 }
 
-func (s *Shard) RecalculateRootHash() {
-	// Sort transactions to ensure consistent ordering
-	sortedTxs := make([][]byte, len(s.Transactions))
-	copy(sortedTxs, s.Transactions)
-
-	// Sort transactions by their string representation
-	sort.Slice(sortedTxs, func(i, j int) bool {
-		return string(sortedTxs[i]) < string(sortedTxs[j])
-	})
-
-	// Build Merkle tree with sorted transactions
-	s.RootHash = calculateMerkleRoot(sortedTxs)
+// ProveTransaction returns a Merkle proof of tx against RootHash
+func (s *Shard) ProveTransaction(tx []byte) ([]merkle.ProofStep, error) {
+	if s.Accumulator == nil || s.Accumulator.Size() != len(s.Transactions) {
+		s.RecalculateRootHash()
+	}
+	i, ok := s.positions[string(tx)]
+	if !ok {
+		return nil, fmt.Errorf("transaction not found in shard")
+	}
+	return s.Accumulator.InclusionProof(i, s.Accumulator.Size())
 }
 
-func calculateMerkleRoot(transactions [][]byte) []byte {
-	if len(transactions) == 0 {
-		return []byte{}
+// ProveAbsence returns a sparse Merkle proof that tx is not held by the
+// shard, checked against MembershipRoot
+func (s *Shard) ProveAbsence(tx []byte) (*merkle.SparseProof, error) {
+	if s.Accumulator == nil || s.Accumulator.Size() != len(s.Transactions) {
+		s.RecalculateRootHash()
+	}
+	key := merkle.KeyFor(tx)
+	if _, ok := s.Membership.Get(key); ok {
+		return nil, fmt.Errorf("transaction is present in shard")
 	}
+	return s.Membership.Prove(key), nil
+}
 
-	// Create a new Merkle tree with the sorted transactions
-	tree := merkle.NewMerkleTree(transactions)
-	return tree.Root.Hash
+func (s *Shard) index(tx []byte, i int) {
+	if _, ok := s.positions[string(tx)]; !ok {
+		s.positions[string(tx)] = i
+	}
+	s.Membership.Update(merkle.KeyFor(tx), tx)
 }
 
 // ForceReduceLoad reduces the load of all shards to simulate low network conditions
 func (sm *ShardManager) ForceReduceLoad() {
-	fmt.Println("\nForcing load reduction on all shards...")
-	for i, shard := range sm.Shards {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.Logger().Info("forcing load reduction on all shards")
+	for _, shard := range sm.Shards {
 		if len(shard.Transactions) > 3 {
 			// Keep only the first 3 transactions
+			sm.placeMu.Lock()
+			for _, tx := range shard.Transactions[3:] {
+				delete(sm.owners, string(tx))
+				sm.placed(tx, -1)
+			}
+			sm.placeMu.Unlock()
 			shard.Transactions = shard.Transactions[:3]
 			shard.States = shard.States[:3]
 			shard.Load = 3
+			// traffic of keys the shard no longer holds no longer loads it
+			held := make(map[string]bool)
+			for _, tx := range shard.Transactions {
+				held[string(PlacementKey(tx))] = true
+			}
+			sm.metrics.Retain(shard.ID, func(key string) bool { return held[key] })
 			shard.RecalculateRootHash()
-			fmt.Printf("Reduced load on shard %d to 3 transactions\n", i)
+			sm.Logger().Info("reduced shard load", logging.Shard(shard.ID), slog.Int("load", shard.Load), logging.Root(shard.RootHash))
 		}
 	}
 }
@@ -342,19 +917,27 @@ func CalculateShardEntropy(shard *Shard) float64 {
 
 // Add a conflict detection method that compares the entropy of two shards
 func (sm *ShardManager) DetectConflictBasedOnEntropy(shardIndex1, shardIndex2 int) bool {
+	sm.mu.RLock()
 	shard1 := sm.Shards[shardIndex1]
 	shard2 := sm.Shards[shardIndex2]
 
+	shard1.mu.RLock()
 	entropy1 := CalculateShardEntropy(shard1)
+	shard1.mu.RUnlock()
+	shard2.mu.RLock()
 	entropy2 := CalculateShardEntropy(shard2)
+	shard2.mu.RUnlock()
+	sm.mu.RUnlock()
 
 	// Compare the entropy values. If the difference is greater than a threshold, it's a conflict.
 	entropyThreshold := 1.5 // You can adjust this threshold based on your requirements
 
-	fmt.Printf("Shard 1 entropy: %.2f, Shard 2 entropy: %.2f\n", entropy1, entropy2)
+	sm.Logger().Debug("compared shard entropy",
+		logging.Shard(shardIndex1), slog.Float64("entropy", entropy1),
+		slog.Int("other_shard", shardIndex2), slog.Float64("other_entropy", entropy2))
 
 	if math.Abs(entropy1-entropy2) > entropyThreshold {
-		fmt.Println("Conflict detected based on entropy!")
+		sm.Logger().Warn("conflict detected based on entropy", logging.Shard(shardIndex1), slog.Int("other_shard", shardIndex2))
 		return true
 	}
 
@@ -0,0 +1,398 @@
+package amf
+
+import (
+	"blockchain_A3/core"
+	"blockchain_A3/merkle"
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rebalanceWithin runs Rebalance and fails if it has not returned in time
+func rebalanceWithin(t *testing.T, sm *ShardManager, d time.Duration) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- sm.Rebalance() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		t.Fatal("Rebalance did not return")
+		return nil
+	}
+}
+
+func TestMergeWaitsForMergedScore(t *testing.T) {
+	policy := DefaultShardPolicy()
+	policy.Hysteresis = 0.1
+	sm := NewShardManager(policy)
+	for i := 0; i < 6; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("S1-%d -> R%d: 1", i, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sm.splitShard(sm.Shards[0], "test"); err != nil {
+		t.Fatal(err)
+	}
+	small := sm.Shards[0]
+	if sm.Shards[1].Load < small.Load {
+		small = sm.Shards[1]
+	}
+	// cross-shard traffic as Relocate records it: the scores of the two
+	// shards add up within the merge limit, but the merged shard's
+	// cross-shard ratio puts it over MaxLoad
+	for i := 0; i < 10; i++ {
+		sm.metrics.Record(small.ID, PlacementKey(small.Transactions[0]), true)
+	}
+
+	if err := rebalanceWithin(t, sm, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.Shards) != 2 {
+		t.Fatalf("have %d shards, want the 2 unmerged ones", len(sm.Shards))
+	}
+	if d := sm.Evaluate(); d.Action != ActionNone {
+		t.Fatalf("policy still wants to %s: %s", d.Action, d.Reason)
+	}
+}
+
+func TestForceReduceLoadForgetsDroppedTraffic(t *testing.T) {
+	sm := NewShardManager(DefaultShardPolicy())
+	for i := 0; i < 8; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sm.ForceReduceLoad()
+
+	for _, m := range sm.Metrics() {
+		shard := sm.ShardByID(m.ShardID)
+		held := make(map[string]bool)
+		for _, tx := range shard.Transactions {
+			held[string(PlacementKey(tx))] = true
+		}
+		if m.Accounts != len(held) {
+			t.Errorf("shard %d counts traffic for %d accounts, holds %d", m.ShardID, m.Accounts, len(held))
+		}
+		for _, k := range m.HotKeys {
+			if !held[k.Key] {
+				t.Errorf("shard %d reports traffic for dropped key %q", m.ShardID, k.Key)
+			}
+		}
+	}
+}
+
+func TestSplitWithoutLoadDividesTransactions(t *testing.T) {
+	policy := DefaultShardPolicy()
+	policy.Metric = MetricThroughput
+	sm := NewShardManager(policy)
+	for i := 0; i < 6; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the window has moved past all the traffic
+	sm.metrics = NewMetricsCollector(policy.Window, time.Now)
+
+	if err := sm.splitShard(sm.Shards[0], "test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.Shards) != 2 || sm.Shards[0].Load == 0 || sm.Shards[1].Load == 0 {
+		t.Fatalf("split left loads %d and %d", sm.Shards[0].Load, sm.Shards[len(sm.Shards)-1].Load)
+	}
+}
+
+func TestHotAccountDoesNotBlockOtherSplits(t *testing.T) {
+	policy := DefaultShardPolicy()
+	sm := NewShardManager(policy)
+	for i := 0; i < 15; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("Alice -> Payee%d: %d", i, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: %d", i, i+1, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := sm.Locate([]byte("Alice -> Payee0: 0"))
+	for _, shard := range sm.Shards {
+		if load := sm.ShardLoad(shard); load > policy.MaxLoad && shard != alice {
+			t.Errorf("shard %d is left at load %.2f", shard.ID, load)
+		}
+	}
+	if err := sm.Rebalance(); err != nil {
+		t.Fatal(err)
+	}
+	if d := sm.LastDecision(); d.Action != ActionNone || len(d.Shards) != 1 || d.Shards[0] != alice.ID {
+		t.Fatalf("last decision %s, want shard %d skipped", d, alice.ID)
+	}
+}
+
+func TestRelocatedOnlyShardDoesNotFailRebalance(t *testing.T) {
+	policy := DefaultShardPolicy()
+	policy.Metric = MetricTxCount
+	sm := NewShardManager(policy)
+	sm.policy.MaxLoad = 100
+	for i := 0; i < 24; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sm.splitShard(sm.Shards[0], "test"); err != nil {
+		t.Fatal(err)
+	}
+	sm.policy.MaxLoad = policy.MaxLoad
+
+	// swap the shards' transactions, as transfers might, so neither holds
+	// a key its ring range covers and one of them is over MaxLoad
+	a, b := sm.Shards[0], sm.Shards[1]
+	fromA, fromB := append([][]byte(nil), a.Transactions...), append([][]byte(nil), b.Transactions...)
+	for _, moves := range []struct {
+		txs      [][]byte
+		from, to *Shard
+	}{{fromA, a, b}, {fromB, b, a}} {
+		// each shard's own transactions come before any moved in
+		for _, tx := range moves.txs {
+			if err := moves.from.RemoveTransaction(0); err != nil {
+				t.Fatal(err)
+			}
+			moves.to.AppendTransaction(tx)
+			sm.Relocate(tx, moves.to)
+		}
+	}
+
+	if err := sm.Rebalance(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentPlacement hammers placement against rebalancing and reads;
+// run it with -race
+func TestConcurrentPlacement(t *testing.T) {
+	sm := NewShardManager(DefaultShardPolicy())
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				tx := []byte(fmt.Sprintf("User%d-%d -> User%d: 1", w, i, i))
+				if err := sm.AddTransaction(tx); err != nil {
+					t.Error(err)
+					return
+				}
+				if sm.Locate(tx) == nil {
+					t.Errorf("%q not located after placement", tx)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := sm.Rebalance(); err != nil {
+				t.Error(err)
+				return
+			}
+			sm.Metrics()
+			sm.Evaluate()
+			if _, err := sm.CommitBeacon(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	total := 0
+	for _, m := range sm.Metrics() {
+		total += m.Transactions
+	}
+	if total != 400 {
+		t.Fatalf("shards hold %d transactions, want 400", total)
+	}
+}
+
+func TestFailedRebalanceKeepsPlacedTransaction(t *testing.T) {
+	policy := DefaultShardPolicy()
+	policy.Metric = MetricTxCount
+	policy.MaxShards = 0
+	sm := NewShardManager(policy)
+	sm.policy.MaxLoad = 1000
+	for i := 0; i < 600; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> Payee%d: 1", i, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// more splits than a rebalance may take before giving up
+	sm.policy.MaxLoad = 1
+
+	tx := []byte("Late -> Payee: 1")
+	if err := sm.AddTransaction(tx); err != nil {
+		t.Fatalf("placed transaction reported as failed: %v", err)
+	}
+	if sm.Locate(tx) == nil {
+		t.Fatal("transaction not held after a failed rebalance")
+	}
+	if d := sm.LastDecision(); d.Err == nil {
+		t.Fatalf("last decision %s does not record the failed rebalance", d)
+	}
+}
+
+func TestRestructuringProofUsesPublishedRoots(t *testing.T) {
+	sm := NewShardManager(DefaultShardPolicy())
+	for i := 0; i < 6; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parent := sm.Shards[0]
+	oldRoot := parent.RootHash
+	if err := sm.splitShard(parent, "test"); err != nil {
+		t.Fatal(err)
+	}
+	changes := sm.Changes()
+	proof := changes[len(changes)-1].Proof
+	if proof == nil {
+		t.Fatal("split carries no proof")
+	}
+	if !bytes.Equal(proof.Old[0].Root, oldRoot) {
+		t.Errorf("proof starts from root %x, shard published %x", proof.Old[0].Root, oldRoot)
+	}
+	for _, s := range proof.New {
+		if root := sm.ShardByID(s.ShardID).RootHash; !bytes.Equal(s.Root, root) {
+			t.Errorf("proof gives shard %d root %x, shard published %x", s.ShardID, s.Root, root)
+		}
+	}
+
+	// a published root that does not match the transactions cannot be
+	// proved, so neither split nor merge goes ahead
+	shards, epoch := len(sm.Shards), sm.Epoch()
+	sm.Shards[0].RootHash = []byte("stale")
+	if err := sm.splitShard(sm.Shards[0], "test"); err == nil || !strings.Contains(err.Error(), "published root") {
+		t.Fatalf("split from a stale root gave %v", err)
+	}
+	if err := sm.merge([2]*Shard{sm.Shards[0], sm.Shards[1]}, Decision{Action: ActionMerge}); err == nil || !strings.Contains(err.Error(), "published root") {
+		t.Fatalf("merge from a stale root gave %v", err)
+	}
+	if len(sm.Shards) != shards || sm.Epoch() != epoch {
+		t.Fatalf("refused restructuring left %d shards at epoch %d, want %d at %d", len(sm.Shards), sm.Epoch(), shards, epoch)
+	}
+}
+
+func TestChildChainsLinkToParentHeads(t *testing.T) {
+	sm := NewShardManager(DefaultShardPolicy())
+	for i := 0; i < 6; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sm.CommitBeacon(); err != nil {
+		t.Fatal(err)
+	}
+	parent := sm.Shards[0]
+	parentHead, err := parent.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.splitShard(parent, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.CommitBeacon(); err != nil {
+		t.Fatal(err)
+	}
+
+	child := sm.Shards[1]
+	if len(child.ParentHeads) != 1 || child.ParentHeads[0].Hash != parentHead.Hash {
+		t.Fatalf("child records parent heads %v, want %v", child.ParentHeads, parentHead)
+	}
+	if got := child.Blocks[0].PrevHash; got != core.LinkHeads([]core.ShardHead{parentHead}) {
+		t.Fatalf("child's first block links to %q", got)
+	}
+	if err := sm.Beacon().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentBeaconCommits(t *testing.T) {
+	sm := NewShardManager(DefaultShardPolicy())
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d-%d -> User%d: 1", w, i, i))); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := sm.CommitBeacon(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	beacon := sm.Beacon()
+	last := make(map[int]int)
+	for h := 1; h <= beacon.Height(); h++ {
+		block, err := beacon.GetBlockByHeight(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, head := range block.Shards {
+			if prev, ok := last[head.ShardID]; ok && head.Height < prev {
+				t.Fatalf("beacon %d commits shard %d at %d after %d", h, head.ShardID, head.Height, prev)
+			}
+			last[head.ShardID] = head.Height
+		}
+	}
+}
+
+func TestBeaconCommitsMembershipRoot(t *testing.T) {
+	sm := NewShardManager(DefaultShardPolicy())
+	for i := 0; i < 4; i++ {
+		if err := sm.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	beacon, err := sm.CommitBeacon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard := sm.Shards[0]
+	head, headProof, err := beacon.ProveShard(shard.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := core.VerifyShardHead(beacon, head, headProof); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(head.MembershipRoot, shard.MembershipRoot) {
+		t.Fatalf("beacon commits membership root %x, shard published %x", head.MembershipRoot, shard.MembershipRoot)
+	}
+
+	missing := []byte("Nobody -> Anyone: 1")
+	absence, err := shard.ProveAbsence(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !absence.VerifyNonInclusion(head.MembershipRoot, merkle.KeyFor(missing)) {
+		t.Fatal("absence does not verify against the committed membership root")
+	}
+
+	// the committed head no longer verifies once its membership root changes
+	head.MembershipRoot = merkle.NewSparseMerkleTree().Root()
+	if err := core.VerifyShardHead(beacon, head, headProof); err == nil {
+		t.Fatal("altered membership root verified against the beacon")
+	}
+}
@@ -0,0 +1,163 @@
+package amf
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrUnknownEpoch = errors.New("unknown topology epoch")
+	ErrNotHeld      = errors.New("transaction not held at epoch")
+)
+
+// ShardInfo describes one shard in a topology
+type ShardInfo struct {
+	ID      int
+	Parents []int      // shards this one was split or merged from
+	Ranges  []KeyRange // ring ranges the shard owns
+}
+
+// Topology is the set of shards in force during one epoch. Every split and
+// merge starts a new epoch.
+type Topology struct {
+	Epoch  uint64
+	Shards []ShardInfo // ordered by ID
+}
+
+// Shard returns the shard with the given ID in the topology
+func (t Topology) Shard(id int) (ShardInfo, bool) {
+	i := sort.Search(len(t.Shards), func(i int) bool { return t.Shards[i].ID >= id })
+	if i < len(t.Shards) && t.Shards[i].ID == id {
+		return t.Shards[i], true
+	}
+	return ShardInfo{}, false
+}
+
+// TopologyChange records one split or merge
+type TopologyChange struct {
+	Epoch    uint64 // epoch the change started
+	Action   Action
+	Parents  []int // shards that existed before
+	Children []int // shards that exist after
+	Moved    int   // transactions that changed shard
+	Reason   string
+	// Proof shows the children hold exactly the parents' transactions
+	Proof *RestructuringProof
+	// Split predicts how the split changes cross-shard traffic, nil for merges
+	Split *SplitReport
+}
+
+func (c TopologyChange) String() string {
+	return fmt.Sprintf("epoch %d: %s %v -> %v (%d moved)", c.Epoch, c.Action, c.Parents, c.Children, c.Moved)
+}
+
+// placement records that a transaction was held by shard from epoch on;
+// shard is -1 once it is dropped
+type placement struct {
+	epoch uint64
+	shard int
+}
+
+// Epoch returns the current topology epoch
+func (sm *ShardManager) Epoch() uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.epoch
+}
+
+// Topology returns the current topology
+func (sm *ShardManager) Topology() Topology {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.topologies[sm.epoch]
+}
+
+// TopologyAt returns the topology in force during epoch
+func (sm *ShardManager) TopologyAt(epoch uint64) (Topology, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if epoch > sm.epoch {
+		return Topology{}, fmt.Errorf("%w: %d", ErrUnknownEpoch, epoch)
+	}
+	return sm.topologies[epoch], nil
+}
+
+// Changes returns every split and merge in the order they happened
+func (sm *ShardManager) Changes() []TopologyChange {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return append([]TopologyChange(nil), sm.changes...)
+}
+
+// ShardAt returns the ID of the shard holding tx at the end of epoch
+func (sm *ShardManager) ShardAt(tx []byte, epoch uint64) (int, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if epoch > sm.epoch {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownEpoch, epoch)
+	}
+
+	sm.placeMu.Lock()
+	defer sm.placeMu.Unlock()
+	history := sm.history[string(tx)]
+	i := sort.Search(len(history), func(i int) bool { return history[i].epoch > epoch })
+	if i == 0 || history[i-1].shard < 0 {
+		return 0, fmt.Errorf("%w: %d", ErrNotHeld, epoch)
+	}
+	return history[i-1].shard, nil
+}
+
+// placed records tx entering shard in the current epoch; the caller must
+// hold placeMu
+func (sm *ShardManager) placed(tx []byte, shard int) {
+	history := sm.history[string(tx)]
+	if n := len(history); n > 0 && history[n-1].epoch == sm.epoch {
+		history[n-1].shard = shard
+	} else {
+		history = append(history, placement{epoch: sm.epoch, shard: shard})
+	}
+	sm.history[string(tx)] = history
+}
+
+// beginEpoch starts the epoch a split or merge takes effect in; the caller
+// must hold mu for writing
+func (sm *ShardManager) beginEpoch() {
+	sm.epoch++
+}
+
+// commitEpoch logs a change and snapshots the topology it produced. When
+// before is given, the change carries a restructuring proof from before to
+// the change's children. The caller has checked the parents' published roots
+// with checkPublished and the children's are freshly computed, so the proof
+// cannot fail unless the change lost or invented transactions.
+func (sm *ShardManager) commitEpoch(change TopologyChange, before ...shardLeaves) {
+	if len(before) > 0 {
+		after := make([]*Shard, len(change.Children))
+		for i, id := range change.Children {
+			after[i] = sm.shardByID(id)
+		}
+		proof, err := proveRestructuring(sm.epoch, change.Action, before, capture(after...))
+		if err != nil {
+			panic(fmt.Sprintf("amf: epoch %d %s is unprovable: %v", sm.epoch, change.Action, err))
+		}
+		change.Proof = proof
+	}
+	change.Epoch = sm.epoch
+	sm.changes = append(sm.changes, change)
+	sm.topologies = append(sm.topologies, sm.snapshot())
+}
+
+// snapshot captures the current topology
+func (sm *ShardManager) snapshot() Topology {
+	t := Topology{Epoch: sm.epoch, Shards: make([]ShardInfo, 0, len(sm.Shards))}
+	for _, shard := range sm.Shards {
+		t.Shards = append(t.Shards, ShardInfo{
+			ID:      shard.ID,
+			Parents: sm.parents[shard.ID],
+			Ranges:  sm.ring.Ranges(shard.ID),
+		})
+	}
+	sort.Slice(t.Shards, func(i, j int) bool { return t.Shards[i].ID < t.Shards[j].ID })
+	return t
+}
@@ -0,0 +1,101 @@
+package consensus
+
+import (
+	"blockchain_A3/core"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMissingCertificate = errors.New("block carries no commit certificate")
+	ErrInvalidCertificate = errors.New("commit certificate is invalid")
+)
+
+// EncodeCertificate serialises the commit messages that finalised a block
+// so they can travel in Block.Data
+func EncodeCertificate(cert []*Message) []byte {
+	var buf []byte
+	putBytes := func(b []byte) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(b)))
+		buf = append(buf, b...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(cert)))
+	for _, m := range cert {
+		putBytes([]byte(m.From))
+		buf = binary.BigEndian.AppendUint64(buf, m.View)
+		buf = binary.BigEndian.AppendUint64(buf, m.Sequence)
+		putBytes([]byte(m.Digest))
+		putBytes(m.Signature)
+	}
+	return buf
+}
+
+// DecodeCertificate parses a certificate produced by EncodeCertificate
+func DecodeCertificate(data []byte) ([]*Message, error) {
+	if len(data) == 0 {
+		return nil, ErrMissingCertificate
+	}
+	var err error
+	take := func(n int) []byte {
+		if err != nil {
+			return nil
+		}
+		if n < 0 || len(data) < n {
+			err = fmt.Errorf("%w: truncated", ErrInvalidCertificate)
+			return nil
+		}
+		b := data[:n]
+		data = data[n:]
+		return b
+	}
+	u32 := func() uint32 {
+		if b := take(4); b != nil {
+			return binary.BigEndian.Uint32(b)
+		}
+		return 0
+	}
+	u64 := func() uint64 {
+		if b := take(8); b != nil {
+			return binary.BigEndian.Uint64(b)
+		}
+		return 0
+	}
+	bytesField := func() []byte {
+		return append([]byte(nil), take(int(u32()))...)
+	}
+
+	count := u32()
+	if err == nil && uint64(count) > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: %d votes in %d bytes", ErrInvalidCertificate, count, len(data))
+	}
+	cert := make([]*Message, 0, count)
+	for i := uint32(0); i < count && err == nil; i++ {
+		m := &Message{Type: MsgCommit}
+		m.From = string(bytesField())
+		m.View = u64()
+		m.Sequence = u64()
+		m.Digest = string(bytesField())
+		m.Signature = bytesField()
+		cert = append(cert, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes", ErrInvalidCertificate)
+	}
+	return cert, nil
+}
+
+// VerifyCertificate checks that a quorum of validators committed the block
+// in a single view
+func VerifyCertificate(vs *ValidatorSet, block *core.Block, cert []*Message) error {
+	if len(cert) == 0 {
+		return ErrMissingCertificate
+	}
+	if !vs.matchingQuorum(cert, MsgCommit, cert[0].View, uint64(block.Index), block.Hash) {
+		return fmt.Errorf("%w: no quorum of commits for block %d", ErrInvalidCertificate, block.Index)
+	}
+	return nil
+}
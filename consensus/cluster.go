@@ -0,0 +1,132 @@
+package consensus
+
+import (
+	"blockchain_A3/core"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	stdsync "sync"
+	"time"
+)
+
+type commitRecord struct {
+	digest string
+	cert   []*Message
+}
+
+// Cluster runs a full validator committee in one process over an
+// InMemoryNetwork and a ManualClock, so every run is deterministic
+type Cluster struct {
+	Validators *ValidatorSet
+	Network    *InMemoryNetwork
+	Clock      *ManualClock
+	Timeout    time.Duration
+	Replicas   []*Replica
+
+	mu      stdsync.Mutex
+	commits map[uint64]commitRecord
+}
+
+// NewCluster creates n validators with fresh keys whose chains are
+// committed up to height committed. validate, if set, is applied by every
+// replica to each proposal.
+func NewCluster(n int, timeout time.Duration, committed uint64, validate func(*core.Block) error) (*Cluster, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("cluster needs at least one validator")
+	}
+	keys := make([]ed25519.PrivateKey, n)
+	validators := make([]Validator, n)
+	for i := range validators {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = priv
+		validators[i] = Validator{ID: fmt.Sprintf("validator-%d", i), PublicKey: pub}
+	}
+	vs, err := NewValidatorSet(validators)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		Validators: vs,
+		Network:    NewInMemoryNetwork(),
+		Clock:      NewManualClock(time.Unix(0, 0).UTC()),
+		Timeout:    timeout,
+		commits:    make(map[uint64]commitRecord),
+	}
+	for i, v := range validators {
+		r, err := NewReplica(ReplicaConfig{
+			ID:         v.ID,
+			Key:        keys[i],
+			Validators: vs,
+			Transport:  c.Network,
+			Clock:      c.Clock,
+			Timeout:    timeout,
+			Committed:  committed,
+			Validate:   validate,
+			OnCommit:   c.recordCommit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.Network.Register(r)
+		c.Replicas = append(c.Replicas, r)
+	}
+	return c, nil
+}
+
+func (c *Cluster) recordCommit(block *core.Block, cert []*Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seq := uint64(block.Index)
+	if _, ok := c.commits[seq]; !ok {
+		c.commits[seq] = commitRecord{digest: block.Hash, cert: cert}
+	}
+}
+
+// Replica looks up a validator's replica by ID
+func (c *Cluster) Replica(id string) *Replica {
+	for _, r := range c.Replicas {
+		if r.ID() == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// Primary returns the replica leading the most advanced view in the cluster
+func (c *Cluster) Primary() *Replica {
+	var view uint64
+	for _, r := range c.Replicas {
+		if v := r.View(); v > view {
+			view = v
+		}
+	}
+	return c.Replica(c.Validators.Primary(view).ID)
+}
+
+// Run delivers messages until the network is quiet
+func (c *Cluster) Run() int {
+	return c.Network.Run()
+}
+
+// AdvanceTime moves the clock forward, fires replica timers and delivers
+// the resulting messages
+func (c *Cluster) AdvanceTime(d time.Duration) {
+	c.Clock.Advance(d)
+	for _, r := range c.Replicas {
+		r.Tick()
+	}
+	c.Run()
+}
+
+// Certificate returns the digest committed at a sequence and its commit
+// certificate
+func (c *Cluster) Certificate(seq uint64) (string, []*Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rec, ok := c.commits[seq]
+	return rec.digest, rec.cert, ok
+}
@@ -0,0 +1,73 @@
+package consensus
+
+import (
+	"blockchain_A3/core"
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrBlockRejected = errors.New("block rejected by consensus")
+
+// Engine finalises candidate blocks before they are committed to the chain
+type Engine interface {
+	// Seal runs consensus on a candidate block, filling in any fields the
+	// engine is responsible for, or returns an error if the block is rejected
+	Seal(ctx context.Context, chain core.ChainReader, block *core.Block) error
+}
+
+// PoWRandomnessEngine attaches proof-of-work derived randomness to a block
+type PoWRandomnessEngine struct{}
+
+func (PoWRandomnessEngine) Seal(ctx context.Context, chain core.ChainReader, block *core.Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	block.Data = []byte(GeneratePoWRandomness(block.Hash))
+	return nil
+}
+
+// DBFTEngine finalises blocks by running PBFT agreement across a validator
+// cluster and stores the resulting commit certificate in Block.Data
+type DBFTEngine struct {
+	cluster *Cluster
+	// MaxRounds bounds how many view changes a single block may wait through
+	MaxRounds int
+}
+
+func NewDBFTEngine(cluster *Cluster) *DBFTEngine {
+	return &DBFTEngine{cluster: cluster, MaxRounds: cluster.Validators.Size() + 1}
+}
+
+func (e *DBFTEngine) Seal(ctx context.Context, chain core.ChainReader, block *core.Block) error {
+	seq := uint64(block.Index)
+	for round := 0; round < e.MaxRounds; round++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p := e.cluster.Primary(); p != nil {
+			_ = p.Propose(block)
+		}
+		e.cluster.Run()
+
+		if digest, cert, ok := e.cluster.Certificate(seq); ok {
+			if digest != block.Hash {
+				return fmt.Errorf("%w: validators committed %s at height %d", ErrBlockRejected, digest, seq)
+			}
+			block.Data = EncodeCertificate(cert)
+			return nil
+		}
+		// no commit this round, so let the timers replace the primary
+		e.cluster.AdvanceTime(e.cluster.Timeout)
+	}
+	return ErrBlockRejected
+}
+
+// VerifySeal checks the block's commit certificate against the validator set
+func (e *DBFTEngine) VerifySeal(chain core.ChainReader, block, parent *core.Block) error {
+	cert, err := DecodeCertificate(block.Data)
+	if err != nil {
+		return err
+	}
+	return VerifyCertificate(e.cluster.Validators, block, cert)
+}
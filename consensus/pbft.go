@@ -0,0 +1,525 @@
+package consensus
+
+import (
+	"blockchain_A3/core"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	stdsync "sync"
+	"time"
+)
+
+var (
+	ErrNotPrimary      = errors.New("replica is not the primary for the current view")
+	ErrViewChanging    = errors.New("replica is changing view")
+	ErrProposalPending = errors.New("a proposal is already pending for this sequence")
+)
+
+// Fault makes a replica misbehave, for simulating Byzantine validators
+type Fault uint8
+
+const (
+	FaultNone Fault = iota
+	// FaultSilent sends no messages at all
+	FaultSilent
+	// FaultEquivocate proposes conflicting blocks to different replicas
+	// when primary
+	FaultEquivocate
+	// FaultConflictingVotes prepares and commits digests that were never proposed
+	FaultConflictingVotes
+)
+
+// ReplicaConfig configures a PBFT replica
+type ReplicaConfig struct {
+	ID         string
+	Key        ed25519.PrivateKey
+	Validators *ValidatorSet
+	Transport  Transport
+	Clock      Clock
+	// Timeout is how long a replica waits for the next commit before it
+	// votes to replace the primary
+	Timeout time.Duration
+	// Committed is the last sequence (block height) already committed
+	Committed uint64
+	// Validate checks a proposed block before the replica prepares it
+	Validate func(*core.Block) error
+	// OnCommit is called with each committed block and its commit certificate
+	OnCommit func(block *core.Block, cert []*Message)
+}
+
+type slotKey struct {
+	view, seq uint64
+}
+
+// slot tracks agreement on one sequence number within one view
+type slot struct {
+	digest     string
+	block      *core.Block
+	prePrepare *Message
+	prepares   map[string]*Message
+	commits    map[string]*Message
+	prepared   bool
+	committed  bool
+}
+
+// preparedCert is the highest proposal this replica saw prepared for the
+// next sequence, carried into view changes so it cannot be lost
+type preparedCert struct {
+	view     uint64
+	digest   string
+	block    *core.Block
+	prepares []*Message
+}
+
+// Replica is a single validator running PBFT. The three-phase exchange
+// (pre-prepare, prepare, commit) orders one block per sequence; a replica
+// that sees no commit within its timeout broadcasts a view-change, and the
+// next primary starts the new view once it holds a quorum of them.
+type Replica struct {
+	mu  stdsync.Mutex
+	cfg ReplicaConfig
+
+	view         uint64
+	targetView   uint64 // view being changed to while viewChanging
+	viewChanging bool
+	committed    uint64
+	timerStart   time.Time
+	fault        Fault
+
+	slots       map[slotKey]*slot
+	prepared    *preparedCert
+	viewChanges map[uint64]map[string]*Message
+	newViewSent map[uint64]bool
+}
+
+func NewReplica(cfg ReplicaConfig) (*Replica, error) {
+	if _, ok := cfg.Validators.Get(cfg.ID); !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownValidator, cfg.ID)
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock{}
+	}
+	return &Replica{
+		cfg:         cfg,
+		committed:   cfg.Committed,
+		timerStart:  cfg.Clock.Now(),
+		slots:       make(map[slotKey]*slot),
+		viewChanges: make(map[uint64]map[string]*Message),
+		newViewSent: make(map[uint64]bool),
+	}, nil
+}
+
+// ID returns the replica's validator ID
+func (r *Replica) ID() string {
+	return r.cfg.ID
+}
+
+// View returns the current view number
+func (r *Replica) View() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.view
+}
+
+// Committed returns the last committed sequence
+func (r *Replica) Committed() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.committed
+}
+
+// IsPrimary reports whether the replica leads the current view
+func (r *Replica) IsPrimary() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.viewChanging && r.cfg.Validators.Primary(r.view).ID == r.cfg.ID
+}
+
+// SetFault switches the replica to Byzantine behaviour
+func (r *Replica) SetFault(f Fault) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fault = f
+}
+
+// Propose starts agreement on a block for the next sequence. Only the
+// primary of the current view may propose.
+func (r *Replica) Propose(block *core.Block) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.viewChanging {
+		return ErrViewChanging
+	}
+	if r.cfg.Validators.Primary(r.view).ID != r.cfg.ID {
+		return ErrNotPrimary
+	}
+	seq := r.committed + 1
+	if uint64(block.Index) != seq {
+		return fmt.Errorf("proposal height %d does not match sequence %d", block.Index, seq)
+	}
+	if s := r.slots[slotKey{r.view, seq}]; s != nil && s.prePrepare != nil {
+		return ErrProposalPending
+	}
+
+	if r.fault == FaultEquivocate {
+		r.equivocate(block, seq)
+		return nil
+	}
+	r.broadcast(&Message{Type: MsgPrePrepare, View: r.view, Sequence: seq, Digest: block.Hash, Block: block})
+	return nil
+}
+
+// equivocate sends the real proposal to half of the committee and a
+// conflicting one to the rest
+func (r *Replica) equivocate(block *core.Block, seq uint64) {
+	alt := *block
+	alt.Nonce++
+	alt.Hash = alt.CalculateHash()
+	for i, v := range r.cfg.Validators.Validators() {
+		if v.ID == r.cfg.ID {
+			continue
+		}
+		b := block
+		if i%2 == 1 {
+			b = &alt
+		}
+		msg := &Message{Type: MsgPrePrepare, View: r.view, Sequence: seq, Digest: b.Hash, Block: b, From: r.cfg.ID}
+		msg.Sign(r.cfg.Key)
+		r.cfg.Transport.Send(v.ID, msg)
+	}
+}
+
+// Tick checks the view timer against the clock and starts a view change
+// if the current primary has not produced a commit in time
+func (r *Replica) Tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cfg.Clock.Now().Sub(r.timerStart) < r.cfg.Timeout {
+		return
+	}
+	next := r.view + 1
+	if r.viewChanging {
+		// the incoming primary failed to start its view as well
+		next = r.targetView + 1
+	}
+	r.startViewChange(next)
+}
+
+// Receive handles a message delivered by the transport
+func (r *Replica) Receive(msg *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.cfg.Validators.Verify(msg); err != nil {
+		return
+	}
+	r.process(msg)
+}
+
+func (r *Replica) process(msg *Message) {
+	switch msg.Type {
+	case MsgPrePrepare:
+		r.onPrePrepare(msg)
+	case MsgPrepare:
+		r.onPrepare(msg)
+	case MsgCommit:
+		r.onCommit(msg)
+	case MsgViewChange:
+		r.onViewChange(msg)
+	case MsgNewView:
+		r.onNewView(msg)
+	}
+}
+
+// broadcast signs a message, sends it to the other replicas and processes
+// it locally
+func (r *Replica) broadcast(msg *Message) {
+	if r.fault == FaultSilent {
+		return
+	}
+	if r.fault == FaultConflictingVotes && (msg.Type == MsgPrepare || msg.Type == MsgCommit) {
+		msg.Digest = core.Hash(fmt.Sprintf("conflict-%s-%d-%d", r.cfg.ID, msg.View, msg.Sequence))
+	}
+	msg.From = r.cfg.ID
+	msg.Sign(r.cfg.Key)
+	r.cfg.Transport.Broadcast(msg)
+	r.process(msg)
+}
+
+func (r *Replica) slot(view, seq uint64) *slot {
+	key := slotKey{view, seq}
+	s, ok := r.slots[key]
+	if !ok {
+		s = &slot{prepares: make(map[string]*Message), commits: make(map[string]*Message)}
+		r.slots[key] = s
+	}
+	return s
+}
+
+func (r *Replica) onPrePrepare(msg *Message) {
+	if r.viewChanging || msg.View != r.view || msg.Sequence != r.committed+1 {
+		return
+	}
+	if msg.From != r.cfg.Validators.Primary(msg.View).ID {
+		return
+	}
+	if err := r.checkProposal(msg.Digest, msg.Block, msg.Sequence); err != nil {
+		return
+	}
+
+	s := r.slot(msg.View, msg.Sequence)
+	if s.prePrepare != nil {
+		if s.digest != msg.Digest {
+			// the primary proposed two blocks for the same slot
+			r.startViewChange(r.view + 1)
+		}
+		return
+	}
+	r.acceptProposal(s, msg, msg.Block)
+}
+
+// checkProposal validates a proposed block against its digest and the
+// application's rules
+func (r *Replica) checkProposal(digest string, block *core.Block, seq uint64) error {
+	if block == nil || block.Hash != digest || block.CalculateHash() != digest {
+		return fmt.Errorf("proposal does not match digest %s", digest)
+	}
+	if uint64(block.Index) != seq {
+		return fmt.Errorf("proposal height %d does not match sequence %d", block.Index, seq)
+	}
+	if r.cfg.Validate != nil {
+		return r.cfg.Validate(block)
+	}
+	return nil
+}
+
+func (r *Replica) acceptProposal(s *slot, msg *Message, block *core.Block) {
+	s.prePrepare = msg
+	s.digest = msg.Digest
+	s.block = block
+	r.broadcast(&Message{Type: MsgPrepare, View: msg.View, Sequence: msg.Sequence, Digest: msg.Digest})
+	r.checkPrepared(msg.View, msg.Sequence, s)
+}
+
+func (r *Replica) onPrepare(msg *Message) {
+	if msg.View < r.view || msg.Sequence <= r.committed {
+		return
+	}
+	s := r.slot(msg.View, msg.Sequence)
+	if _, ok := s.prepares[msg.From]; ok {
+		return
+	}
+	s.prepares[msg.From] = msg
+	r.checkPrepared(msg.View, msg.Sequence, s)
+}
+
+func (r *Replica) checkPrepared(view, seq uint64, s *slot) {
+	if s.prepared || s.prePrepare == nil || view != r.view {
+		return
+	}
+	matching := matchingVotes(s.prepares, s.digest)
+	if len(matching) < r.cfg.Validators.Quorum() {
+		return
+	}
+	s.prepared = true
+	if r.prepared == nil || r.prepared.view < view {
+		r.prepared = &preparedCert{view: view, digest: s.digest, block: s.block, prepares: matching}
+	}
+	r.broadcast(&Message{Type: MsgCommit, View: view, Sequence: seq, Digest: s.digest})
+	r.checkCommitted(view, seq, s)
+}
+
+func (r *Replica) onCommit(msg *Message) {
+	if msg.View < r.view || msg.Sequence <= r.committed {
+		return
+	}
+	s := r.slot(msg.View, msg.Sequence)
+	if _, ok := s.commits[msg.From]; ok {
+		return
+	}
+	s.commits[msg.From] = msg
+	r.checkCommitted(msg.View, msg.Sequence, s)
+}
+
+func (r *Replica) checkCommitted(view, seq uint64, s *slot) {
+	if s.committed || !s.prepared {
+		return
+	}
+	cert := matchingVotes(s.commits, s.digest)
+	if len(cert) < r.cfg.Validators.Quorum() {
+		return
+	}
+	s.committed = true
+	r.committed = seq
+	r.prepared = nil
+	r.timerStart = r.cfg.Clock.Now()
+	for key := range r.slots {
+		if key.seq <= seq {
+			delete(r.slots, key)
+		}
+	}
+	if r.cfg.OnCommit != nil {
+		r.cfg.OnCommit(s.block, cert)
+	}
+}
+
+// matchingVotes returns the votes for digest in validator order
+func matchingVotes(votes map[string]*Message, digest string) []*Message {
+	var out []*Message
+	for _, v := range votes {
+		if v.Digest == digest {
+			out = append(out, v)
+		}
+	}
+	sortMessages(out)
+	return out
+}
+
+func sortMessages(msgs []*Message) {
+	for i := 1; i < len(msgs); i++ {
+		for j := i; j > 0 && msgs[j].From < msgs[j-1].From; j-- {
+			msgs[j], msgs[j-1] = msgs[j-1], msgs[j]
+		}
+	}
+}
+
+// startViewChange abandons the current view and votes to move to view,
+// reporting the highest proposal this replica has prepared
+func (r *Replica) startViewChange(view uint64) {
+	if view <= r.view || (r.viewChanging && view <= r.targetView) {
+		return
+	}
+	r.viewChanging = true
+	r.targetView = view
+	r.timerStart = r.cfg.Clock.Now()
+
+	msg := &Message{Type: MsgViewChange, View: view, Sequence: r.committed}
+	if p := r.prepared; p != nil {
+		msg.PreparedView = p.view
+		msg.Digest = p.digest
+		msg.Block = p.block
+		msg.Prepared = p.prepares
+	}
+	r.broadcast(msg)
+}
+
+// validViewChange checks a view-change message, including its prepared
+// certificate when one is reported
+func (r *Replica) validViewChange(msg *Message) bool {
+	if msg.Type != MsgViewChange {
+		return false
+	}
+	if len(msg.Prepared) == 0 {
+		return msg.Digest == "" && msg.Block == nil
+	}
+	if msg.PreparedView >= msg.View || msg.Block == nil || msg.Block.Hash != msg.Digest || msg.Block.CalculateHash() != msg.Digest {
+		return false
+	}
+	return r.cfg.Validators.matchingQuorum(msg.Prepared, MsgPrepare, msg.PreparedView, msg.Sequence+1, msg.Digest)
+}
+
+func (r *Replica) onViewChange(msg *Message) {
+	if msg.View <= r.view || !r.validViewChange(msg) {
+		return
+	}
+	votes, ok := r.viewChanges[msg.View]
+	if !ok {
+		votes = make(map[string]*Message)
+		r.viewChanges[msg.View] = votes
+	}
+	if _, seen := votes[msg.From]; seen {
+		return
+	}
+	votes[msg.From] = msg
+
+	// f+1 votes prove at least one honest replica timed out, so join them
+	if len(votes) > r.cfg.Validators.MaxFaulty() {
+		r.startViewChange(msg.View)
+	}
+
+	if r.cfg.Validators.Primary(msg.View).ID != r.cfg.ID || r.newViewSent[msg.View] {
+		return
+	}
+	if len(votes) < r.cfg.Validators.Quorum() {
+		return
+	}
+	proof := make([]*Message, 0, len(votes))
+	for _, vc := range votes {
+		proof = append(proof, vc)
+	}
+	sortMessages(proof)
+	proof = proof[:r.cfg.Validators.Quorum()]
+
+	r.newViewSent[msg.View] = true
+	nv := &Message{Type: MsgNewView, View: msg.View, Sequence: r.committed, ViewChanges: proof}
+	if p := highestPrepared(proof, r.committed); p != nil {
+		nv.Digest = p.Digest
+		nv.Block = p.Block
+	}
+	r.broadcast(nv)
+}
+
+// highestPrepared picks the proposal prepared in the latest view among the
+// view-changes, which the new primary must re-propose
+func highestPrepared(viewChanges []*Message, committed uint64) *Message {
+	var best *Message
+	for _, vc := range viewChanges {
+		if len(vc.Prepared) == 0 || vc.Sequence != committed {
+			continue
+		}
+		if best == nil || vc.PreparedView > best.PreparedView {
+			best = vc
+		}
+	}
+	return best
+}
+
+func (r *Replica) onNewView(msg *Message) {
+	if msg.View <= r.view || msg.From != r.cfg.Validators.Primary(msg.View).ID {
+		return
+	}
+	senders := make(map[string]struct{})
+	for _, vc := range msg.ViewChanges {
+		if vc.View != msg.View || r.cfg.Validators.Verify(vc) != nil || !r.validViewChange(vc) {
+			return
+		}
+		senders[vc.From] = struct{}{}
+	}
+	if len(senders) < r.cfg.Validators.Quorum() {
+		return
+	}
+	expected := ""
+	if p := highestPrepared(msg.ViewChanges, r.committed); p != nil {
+		expected = p.Digest
+	}
+	if msg.Digest != expected {
+		return
+	}
+
+	r.view = msg.View
+	r.viewChanging = false
+	r.targetView = 0
+	r.timerStart = r.cfg.Clock.Now()
+	for v := range r.viewChanges {
+		if v <= msg.View {
+			delete(r.viewChanges, v)
+		}
+	}
+	for key := range r.slots {
+		if key.view < msg.View {
+			delete(r.slots, key)
+		}
+	}
+
+	// the re-proposal acts as the new view's pre-prepare
+	if msg.Block != nil {
+		if err := r.checkProposal(msg.Digest, msg.Block, r.committed+1); err != nil {
+			return
+		}
+		r.acceptProposal(r.slot(msg.View, r.committed+1), &Message{
+			Type: MsgPrePrepare, View: msg.View, Sequence: r.committed + 1, Digest: msg.Digest, From: msg.From,
+		}, msg.Block)
+	}
+}
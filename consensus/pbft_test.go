@@ -0,0 +1,151 @@
+package consensus
+
+import (
+	"blockchain_A3/core"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+func TestQuorumsIntersectInAnHonestValidator(t *testing.T) {
+	for n := 1; n <= 10; n++ {
+		c, err := NewCluster(n, testTimeout, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs := c.Validators
+		f, q := vs.MaxFaulty(), vs.Quorum()
+		if overlap := 2*q - n; overlap < f+1 {
+			t.Errorf("n=%d: quorums of %d overlap in %d validators, want at least %d", n, q, overlap, f+1)
+		}
+		if q > n-f {
+			t.Errorf("n=%d: quorum %d cannot be reached by the %d honest validators", n, q, n-f)
+		}
+	}
+}
+
+// runToCommit proposes block from whoever leads the cluster, letting
+// timeouts replace faulty primaries, until some replica commits it
+func runToCommit(t *testing.T, c *Cluster, block *core.Block) {
+	t.Helper()
+	for round := 0; round <= 2*c.Validators.Size(); round++ {
+		if p := c.Primary(); p != nil {
+			_ = p.Propose(block)
+		}
+		c.Run()
+		if _, _, ok := c.Certificate(uint64(block.Index)); ok {
+			return
+		}
+		c.AdvanceTime(c.Timeout)
+	}
+	t.Fatalf("no commit for height %d", block.Index)
+}
+
+func TestClusterCommitsWithFaultyValidators(t *testing.T) {
+	faults := []struct {
+		name  string
+		fault Fault
+	}{
+		{"silent", FaultSilent},
+		{"equivocating", FaultEquivocate},
+		{"conflicting votes", FaultConflictingVotes},
+	}
+	for n := 4; n <= 7; n++ {
+		for _, tc := range faults {
+			t.Run(fmt.Sprintf("n=%d/%s", n, tc.name), func(t *testing.T) {
+				c, err := NewCluster(n, testTimeout, 0, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the faulty validators are the first primaries in rotation
+				f := c.Validators.MaxFaulty()
+				faulty := make(map[string]bool)
+				for _, r := range c.Replicas[:f] {
+					r.SetFault(tc.fault)
+					faulty[r.ID()] = true
+				}
+				committed := make(map[string]string)
+				for _, r := range c.Replicas {
+					r := r
+					r.cfg.OnCommit = func(block *core.Block, cert []*Message) {
+						committed[r.ID()] = block.Hash
+						c.recordCommit(block, cert)
+					}
+				}
+
+				block := core.CreateBlock(1, nil, "genesis")
+				runToCommit(t, c, &block)
+				// let the remaining replicas catch up on the same view
+				c.Run()
+
+				digest, cert, _ := c.Certificate(1)
+				for _, r := range c.Replicas {
+					if faulty[r.ID()] {
+						continue
+					}
+					if r.Committed() != 1 {
+						t.Errorf("honest %s committed %d, want 1", r.ID(), r.Committed())
+					}
+					if got := committed[r.ID()]; got != digest {
+						t.Errorf("honest %s committed %s, cluster committed %s", r.ID(), got, digest)
+					}
+				}
+				if got := len(cert); got < c.Validators.Quorum() {
+					t.Errorf("certificate has %d commits, want %d", got, c.Validators.Quorum())
+				}
+				for _, m := range cert {
+					if faulty[m.From] && tc.fault == FaultSilent {
+						t.Errorf("silent %s appears in the certificate", m.From)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCertificateVerifiesAgainstCommittedBlock(t *testing.T) {
+	c, err := NewCluster(4, testTimeout, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := core.CreateBlock(1, nil, "genesis")
+	runToCommit(t, c, &block)
+	_, cert, _ := c.Certificate(1)
+
+	decoded, err := DecodeCertificate(EncodeCertificate(cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCertificate(c.Validators, &block, decoded); err != nil {
+		t.Fatalf("certificate rejected: %v", err)
+	}
+	other := core.CreateBlock(1, nil, "other")
+	if err := VerifyCertificate(c.Validators, &other, decoded); err == nil {
+		t.Fatal("certificate accepted for a different block")
+	}
+	if err := VerifyCertificate(c.Validators, &block, decoded[:c.Validators.Quorum()-1]); err == nil {
+		t.Fatal("certificate accepted without a quorum")
+	}
+}
+
+func TestDBFTEngineSealsAfterViewChange(t *testing.T) {
+	c, err := NewCluster(7, testTimeout, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Replicas[0].SetFault(FaultSilent)
+	engine := NewDBFTEngine(c)
+	block := core.CreateBlock(1, nil, "genesis")
+	if err := engine.Seal(context.Background(), nil, &block); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.VerifySeal(nil, &block, nil); err != nil {
+		t.Fatalf("seal rejected: %v", err)
+	}
+	if view := c.Replicas[1].View(); view == 0 {
+		t.Fatal("silent primary was not replaced")
+	}
+}
@@ -1,8 +1,14 @@
 package consensus
 
 import (
+	"blockchain_A3/core"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
 	"time"
 )
 
@@ -21,3 +27,174 @@ func GeneratePoWRandomness(data string) string {
 	}
 	return fmt.Sprintf("%x", time.Now().UnixNano())
 }
+
+var (
+	ErrBadDifficulty = errors.New("block bits do not match the expected difficulty")
+	ErrTargetNotMet  = errors.New("block hash does not meet its target")
+	ErrBadTimestamp  = errors.New("block timestamp is out of range")
+)
+
+// maxFutureDrift is how far ahead of local time a block timestamp may be
+const maxFutureDrift = 2 * time.Hour
+
+// PoWConfig controls mining and difficulty adjustment
+type PoWConfig struct {
+	InitialBits      uint32        // difficulty of the first blocks
+	PowLimitBits     uint32        // easiest difficulty retargeting may reach
+	TargetBlockTime  time.Duration // desired time between blocks
+	RetargetInterval int           // number of blocks between retargets
+	Workers          int           // mining goroutines; defaults to GOMAXPROCS
+}
+
+// DefaultPoWConfig returns a configuration needing about 2^16 hashes per block
+func DefaultPoWConfig() PoWConfig {
+	limit := new(big.Int).Lsh(big.NewInt(1), 256-16)
+	bits := core.BigToCompact(limit.Sub(limit, big.NewInt(1)))
+	return PoWConfig{
+		InitialBits:      bits,
+		PowLimitBits:     bits,
+		TargetBlockTime:  10 * time.Second,
+		RetargetInterval: 10,
+	}
+}
+
+// maxRetargetFactor bounds how far one retarget may move the difficulty
+const maxRetargetFactor = 4
+
+// PoWEngine mines blocks by searching for a nonce whose header hash meets the
+// block's target, and verifies that committed blocks did so. It implements
+// both Engine and core.SealVerifier.
+type PoWEngine struct {
+	cfg PoWConfig
+}
+
+// NewPoWEngine creates a proof-of-work engine
+func NewPoWEngine(cfg PoWConfig) *PoWEngine {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0)
+	}
+	if cfg.RetargetInterval <= 0 {
+		cfg.RetargetInterval = 1
+	}
+	return &PoWEngine{cfg: cfg}
+}
+
+// NextBits returns the difficulty required of a block built on parent.
+// Every RetargetInterval blocks the target is scaled by how long the last
+// interval actually took compared to the desired block time.
+func (e *PoWEngine) NextBits(chain core.ChainReader, parent *core.Block) (uint32, error) {
+	height := parent.Index + 1
+	if parent.Index == 0 || parent.Bits == 0 {
+		return e.cfg.InitialBits, nil
+	}
+	if height%e.cfg.RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	// Walk back along this block's own branch to the start of the interval
+	first := parent
+	for i := 1; i < e.cfg.RetargetInterval && first.Index > 0; i++ {
+		prev, err := chain.GetBlockByHash(first.PrevHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load ancestor of block %d: %v", first.Index, err)
+		}
+		first = prev
+	}
+
+	blocks := int64(parent.Index - first.Index)
+	if blocks <= 0 {
+		return parent.Bits, nil
+	}
+	expected := int64(e.cfg.TargetBlockTime) * blocks
+	actual := int64(parent.Timestamp.Sub(first.Timestamp))
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	target := core.CompactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if limit := core.CompactToBig(e.cfg.PowLimitBits); target.Cmp(limit) > 0 {
+		target = limit
+	}
+	return core.BigToCompact(target), nil
+}
+
+// Seal sets the block's difficulty and mines a nonce for it, splitting the
+// nonce space across worker goroutines. It returns ctx.Err() if cancelled.
+func (e *PoWEngine) Seal(ctx context.Context, chain core.ChainReader, block *core.Block) error {
+	parent, err := chain.GetBlockByHash(block.PrevHash)
+	if err != nil {
+		return fmt.Errorf("failed to load parent block: %v", err)
+	}
+	bits, err := e.NextBits(chain, parent)
+	if err != nil {
+		return err
+	}
+	block.Bits = bits
+	target := core.CompactToBig(bits)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan uint64, e.cfg.Workers)
+	var wg sync.WaitGroup
+	for w := 0; w < e.cfg.Workers; w++ {
+		wg.Add(1)
+		go func(start uint64) {
+			defer wg.Done()
+			candidate := *block
+			for nonce := start; ; nonce += uint64(e.cfg.Workers) {
+				// Checking for cancellation on every hash would dominate the loop
+				if nonce%1024 == start%1024 && ctx.Err() != nil {
+					return
+				}
+				candidate.Nonce = nonce
+				hash := sha256.Sum256(candidate.HeaderBytes())
+				if core.HashMeetsTarget(hash[:], target) {
+					found <- nonce
+					return
+				}
+			}
+		}(uint64(w))
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	select {
+	case nonce, ok := <-found:
+		if !ok {
+			return ctx.Err()
+		}
+		block.Nonce = nonce
+		block.Hash = block.CalculateHash()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// VerifySeal checks that a block carries the expected difficulty and that
+// its hash meets the target. Timestamps feed difficulty adjustment, so they
+// must not go backwards or run far ahead of local time.
+func (e *PoWEngine) VerifySeal(chain core.ChainReader, block, parent *core.Block) error {
+	if block.Timestamp.Before(parent.Timestamp) || block.Timestamp.After(time.Now().Add(maxFutureDrift)) {
+		return ErrBadTimestamp
+	}
+	bits, err := e.NextBits(chain, parent)
+	if err != nil {
+		return err
+	}
+	if block.Bits != bits {
+		return fmt.Errorf("%w: got %08x, want %08x", ErrBadDifficulty, block.Bits, bits)
+	}
+	if !block.MeetsTarget() {
+		return ErrTargetNotMet
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package consensus
+
+import (
+	stdsync "sync"
+	"time"
+)
+
+// Clock supplies the time used for consensus timeouts
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock reads the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock only moves when advanced, making timeouts deterministic
+type ManualClock struct {
+	mu  stdsync.Mutex
+	now time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Transport carries protocol messages between validators
+type Transport interface {
+	// Broadcast sends a message to every other validator
+	Broadcast(msg *Message)
+	// Send sends a message to a single validator
+	Send(to string, msg *Message)
+}
+
+type envelope struct {
+	to  string
+	msg *Message
+}
+
+// InMemoryNetwork is a deterministic single-process transport. Messages are
+// queued in send order and only delivered when the network is stepped.
+type InMemoryNetwork struct {
+	mu       stdsync.Mutex
+	order    []string
+	replicas map[string]*Replica
+	queue    []envelope
+	filter   func(to string, msg *Message) bool
+}
+
+func NewInMemoryNetwork() *InMemoryNetwork {
+	return &InMemoryNetwork{replicas: make(map[string]*Replica)}
+}
+
+// Register attaches a replica so it receives messages addressed to it
+func (n *InMemoryNetwork) Register(r *Replica) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.replicas[r.ID()]; !ok {
+		n.order = append(n.order, r.ID())
+	}
+	n.replicas[r.ID()] = r
+}
+
+// SetFilter installs a hook that drops a queued message when it returns
+// false, simulating partitions and lossy links. A nil filter delivers all.
+func (n *InMemoryNetwork) SetFilter(filter func(to string, msg *Message) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.filter = filter
+}
+
+func (n *InMemoryNetwork) Broadcast(msg *Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, id := range n.order {
+		if id != msg.From {
+			n.queue = append(n.queue, envelope{to: id, msg: msg})
+		}
+	}
+}
+
+func (n *InMemoryNetwork) Send(to string, msg *Message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queue = append(n.queue, envelope{to: to, msg: msg})
+}
+
+// Pending returns the number of queued messages
+func (n *InMemoryNetwork) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.queue)
+}
+
+// Step delivers the oldest queued message and reports whether there was one
+func (n *InMemoryNetwork) Step() bool {
+	n.mu.Lock()
+	if len(n.queue) == 0 {
+		n.mu.Unlock()
+		return false
+	}
+	env := n.queue[0]
+	n.queue = n.queue[1:]
+	r := n.replicas[env.to]
+	deliver := r != nil && (n.filter == nil || n.filter(env.to, env.msg))
+	n.mu.Unlock()
+
+	if deliver {
+		r.Receive(env.msg)
+	}
+	return true
+}
+
+// Run steps the network until no messages remain and returns how many were
+// processed
+func (n *InMemoryNetwork) Run() int {
+	steps := 0
+	for n.Step() {
+		steps++
+	}
+	return steps
+}
@@ -0,0 +1,193 @@
+package consensus
+
+import (
+	"blockchain_A3/core"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownValidator = errors.New("message from unknown validator")
+	ErrBadSignature     = errors.New("message signature is invalid")
+)
+
+// Validator is a member of the consensus committee
+type Validator struct {
+	ID        string
+	PublicKey ed25519.PublicKey
+}
+
+// ValidatorSet is an ordered committee of n validators tolerating
+// f = (n-1)/3 Byzantine members
+type ValidatorSet struct {
+	validators []Validator
+	index      map[string]int
+}
+
+// NewValidatorSet creates a committee; the order decides primary rotation
+func NewValidatorSet(validators []Validator) (*ValidatorSet, error) {
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("validator set is empty")
+	}
+	vs := &ValidatorSet{index: make(map[string]int)}
+	for _, v := range validators {
+		if _, dup := vs.index[v.ID]; dup {
+			return nil, fmt.Errorf("duplicate validator %s", v.ID)
+		}
+		vs.index[v.ID] = len(vs.validators)
+		vs.validators = append(vs.validators, v)
+	}
+	return vs, nil
+}
+
+// Size returns the number of validators
+func (vs *ValidatorSet) Size() int {
+	return len(vs.validators)
+}
+
+// MaxFaulty returns f, the number of Byzantine validators tolerated
+func (vs *ValidatorSet) MaxFaulty() int {
+	return (len(vs.validators) - 1) / 3
+}
+
+// Quorum returns n-f, the number of matching votes needed to progress. Any
+// two quorums then share at least f+1 validators, one of them honest, which
+// 2f+1 does not guarantee when n is not 3f+1.
+func (vs *ValidatorSet) Quorum() int {
+	return len(vs.validators) - vs.MaxFaulty()
+}
+
+// Primary returns the validator that proposes blocks in a view
+func (vs *ValidatorSet) Primary(view uint64) Validator {
+	return vs.validators[view%uint64(len(vs.validators))]
+}
+
+// Get looks up a validator by ID
+func (vs *ValidatorSet) Get(id string) (Validator, bool) {
+	i, ok := vs.index[id]
+	if !ok {
+		return Validator{}, false
+	}
+	return vs.validators[i], true
+}
+
+// Validators returns the committee in rotation order
+func (vs *ValidatorSet) Validators() []Validator {
+	return append([]Validator{}, vs.validators...)
+}
+
+// MessageType identifies a step of the PBFT protocol
+type MessageType uint8
+
+const (
+	MsgPrePrepare MessageType = iota + 1
+	MsgPrepare
+	MsgCommit
+	MsgViewChange
+	MsgNewView
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MsgPrePrepare:
+		return "pre-prepare"
+	case MsgPrepare:
+		return "prepare"
+	case MsgCommit:
+		return "commit"
+	case MsgViewChange:
+		return "view-change"
+	case MsgNewView:
+		return "new-view"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// Message is a signed PBFT protocol message. Sequence numbers are block
+// heights and digests are block hashes.
+type Message struct {
+	Type     MessageType
+	View     uint64
+	Sequence uint64
+	Digest   string
+	From     string
+
+	// Block is the proposal carried by a pre-prepare, by a view-change that
+	// reports a prepared proposal, and by a new-view that re-proposes one
+	Block *core.Block
+
+	// PreparedView and Prepared hold a view-change's proof that Digest was
+	// prepared: a quorum of prepare messages from that view
+	PreparedView uint64
+	Prepared     []*Message
+
+	// ViewChanges holds the quorum of view-change messages justifying a new-view
+	ViewChanges []*Message
+
+	Signature []byte
+}
+
+// SigningBytes returns the bytes covered by the message signature. Nested
+// messages are bound by their own signatures, which are verified separately.
+func (m *Message) SigningBytes() []byte {
+	var buf []byte
+	putString := func(s string) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+		buf = append(buf, s...)
+	}
+	putNested := func(msgs []*Message) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(msgs)))
+		for _, nested := range msgs {
+			sum := sha256.Sum256(append(nested.SigningBytes(), nested.Signature...))
+			buf = append(buf, sum[:]...)
+		}
+	}
+
+	putString("pbft-v1")
+	buf = append(buf, byte(m.Type))
+	buf = binary.BigEndian.AppendUint64(buf, m.View)
+	buf = binary.BigEndian.AppendUint64(buf, m.Sequence)
+	putString(m.Digest)
+	putString(m.From)
+	buf = binary.BigEndian.AppendUint64(buf, m.PreparedView)
+	putNested(m.Prepared)
+	putNested(m.ViewChanges)
+	return buf
+}
+
+// Sign signs the message with a validator key
+func (m *Message) Sign(priv ed25519.PrivateKey) {
+	m.Signature = ed25519.Sign(priv, m.SigningBytes())
+}
+
+// Verify checks that the message is signed by the validator it claims to be from
+func (vs *ValidatorSet) Verify(m *Message) error {
+	v, ok := vs.Get(m.From)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownValidator, m.From)
+	}
+	if !ed25519.Verify(v.PublicKey, m.SigningBytes(), m.Signature) {
+		return fmt.Errorf("%w: %s from %s", ErrBadSignature, m.Type, m.From)
+	}
+	return nil
+}
+
+// matchingQuorum counts distinct, validly signed messages of the given type,
+// view, sequence and digest, and reports whether they reach a quorum
+func (vs *ValidatorSet) matchingQuorum(msgs []*Message, typ MessageType, view, seq uint64, digest string) bool {
+	seen := make(map[string]struct{})
+	for _, m := range msgs {
+		if m.Type != typ || m.View != view || m.Sequence != seq || m.Digest != digest {
+			continue
+		}
+		if vs.Verify(m) != nil {
+			continue
+		}
+		seen[m.From] = struct{}{}
+	}
+	return len(seen) >= vs.Quorum()
+}
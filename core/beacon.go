@@ -0,0 +1,357 @@
+package core
+
+import (
+	"blockchain_A3/merkle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	domainBeaconHeader = "beacon-header"
+	domainShardHead    = "shard-head"
+)
+
+var (
+	ErrUnknownShard   = errors.New("shard is not committed by beacon block")
+	ErrBadShardProof  = errors.New("shard inclusion proof is invalid")
+	ErrNotShardBlock  = errors.New("block does not carry shard payloads")
+	ErrPayloadMissing = errors.New("payload not found in shard block")
+	ErrShardRegressed = errors.New("shard head is behind its last commit")
+)
+
+// ShardHead is the head of one shard's chain as committed by a beacon block
+type ShardHead struct {
+	ShardID  int
+	Height   int    // index of the shard's head block
+	Hash     string // hash of the shard's head block
+	RootHash []byte // shard transaction root at the head block
+	// MembershipRoot is the root of the shard's sparse membership tree at
+	// the head block, which non-inclusion proofs are checked against
+	MembershipRoot []byte
+}
+
+// Leaf returns the canonical encoding the beacon block's ShardRoot commits to
+func (h ShardHead) Leaf() []byte {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	e.string(domainShardHead)
+	e.int64(int64(h.ShardID))
+	e.int64(int64(h.Height))
+	e.string(h.Hash)
+	e.bytes(h.RootHash)
+	e.bytes(h.MembershipRoot)
+	return e.buf
+}
+
+// LinkHeads returns the PrevHash of the first block of a shard split or
+// merged from shards with the given heads, linking its chain to theirs
+func LinkHeads(parents []ShardHead) string {
+	parents = append([]ShardHead(nil), parents...)
+	sort.Slice(parents, func(i, j int) bool { return parents[i].ShardID < parents[j].ShardID })
+	return hex.EncodeToString(merkle.Root(shardLeaves(parents)))
+}
+
+// BeaconBlock periodically commits the head of every shard chain
+type BeaconBlock struct {
+	Index     int
+	Timestamp time.Time
+	PrevHash  string
+	ShardRoot string      // hex merkle root over the Leaf of each of Shards
+	Shards    []ShardHead // ordered by ShardID
+	Hash      string
+}
+
+// HeaderBytes returns the canonical encoding of the fields covered by the
+// beacon block hash
+func (b *BeaconBlock) HeaderBytes() []byte {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	e.string(domainBeaconHeader)
+	e.int64(int64(b.Index))
+	e.int64(b.Timestamp.UnixNano())
+	e.string(b.PrevHash)
+	e.string(b.ShardRoot)
+	return e.buf
+}
+
+// CalculateHash recomputes the hash of the beacon block from its header
+func (b *BeaconBlock) CalculateHash() string {
+	return Hash(string(b.HeaderBytes()))
+}
+
+func shardLeaves(heads []ShardHead) [][]byte {
+	leaves := make([][]byte, len(heads))
+	for i, h := range heads {
+		leaves[i] = h.Leaf()
+	}
+	return leaves
+}
+
+// ProveShard returns the committed head of a shard and a merkle proof of it
+// against ShardRoot
+func (b *BeaconBlock) ProveShard(shardID int) (ShardHead, []merkle.ProofStep, error) {
+	i := sort.Search(len(b.Shards), func(i int) bool { return b.Shards[i].ShardID >= shardID })
+	if i == len(b.Shards) || b.Shards[i].ShardID != shardID {
+		return ShardHead{}, nil, fmt.Errorf("%w: shard %d at beacon height %d", ErrUnknownShard, shardID, b.Index)
+	}
+	proof, err := merkle.NewMerkleTree(shardLeaves(b.Shards)).GenerateProofAt(i)
+	if err != nil {
+		return ShardHead{}, nil, err
+	}
+	return b.Shards[i], proof, nil
+}
+
+// VerifyShardHead checks that the beacon block commits to head. Only the
+// beacon header is trusted; its Shards list is not consulted.
+func VerifyShardHead(beacon *BeaconBlock, head ShardHead, proof []merkle.ProofStep) error {
+	if beacon.CalculateHash() != beacon.Hash {
+		return fmt.Errorf("%w: beacon block %d", ErrInvalidHash, beacon.Index)
+	}
+	root, err := hex.DecodeString(beacon.ShardRoot)
+	if err != nil || !merkle.VerifyProof(head.Leaf(), proof, root) {
+		return fmt.Errorf("%w: shard %d head not under beacon root", ErrBadShardProof, head.ShardID)
+	}
+	return nil
+}
+
+// BeaconChain is the root chain committing every shard's head
+type BeaconChain struct {
+	mu     sync.RWMutex
+	blocks []*BeaconBlock
+	// heads holds the latest committed head of every shard
+	heads map[int]ShardHead
+}
+
+// NewBeaconChain creates a beacon chain holding a genesis block that commits
+// no shards
+func NewBeaconChain() *BeaconChain {
+	genesis := newBeaconBlock(0, "", nil)
+	return &BeaconChain{blocks: []*BeaconBlock{genesis}, heads: make(map[int]ShardHead)}
+}
+
+func newBeaconBlock(index int, prevHash string, heads []ShardHead) *BeaconBlock {
+	heads = append([]ShardHead(nil), heads...)
+	sort.Slice(heads, func(i, j int) bool { return heads[i].ShardID < heads[j].ShardID })
+	b := &BeaconBlock{
+		Index:     index,
+		Timestamp: time.Now().UTC(),
+		PrevHash:  prevHash,
+		ShardRoot: hex.EncodeToString(merkle.Root(shardLeaves(heads))),
+		Shards:    heads,
+	}
+	b.Hash = b.CalculateHash()
+	return b
+}
+
+// Commit appends a beacon block committing the given shard heads. A shard's
+// head may not fall below, or fork from, the head last committed for it.
+func (bc *BeaconChain) Commit(heads []ShardHead) (*BeaconBlock, error) {
+	seen := make(map[int]bool, len(heads))
+	for _, h := range heads {
+		if seen[h.ShardID] {
+			return nil, fmt.Errorf("shard %d committed twice", h.ShardID)
+		}
+		seen[h.ShardID] = true
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, h := range heads {
+		last, ok := bc.heads[h.ShardID]
+		if ok && (h.Height < last.Height || h.Height == last.Height && h.Hash != last.Hash) {
+			return nil, fmt.Errorf("%w: shard %d at height %d, committed %d", ErrShardRegressed, h.ShardID, h.Height, last.Height)
+		}
+	}
+	parent := bc.blocks[len(bc.blocks)-1]
+	b := newBeaconBlock(parent.Index+1, parent.Hash, heads)
+	bc.blocks = append(bc.blocks, b)
+	for _, h := range heads {
+		bc.heads[h.ShardID] = h
+	}
+	return b, nil
+}
+
+// Head returns the latest beacon block
+func (bc *BeaconChain) Head() *BeaconBlock {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.blocks[len(bc.blocks)-1]
+}
+
+// Height returns the index of the latest beacon block
+func (bc *BeaconChain) Height() int {
+	return bc.Head().Index
+}
+
+// GetBlockByHeight returns the beacon block at the given height
+func (bc *BeaconChain) GetBlockByHeight(height int) (*BeaconBlock, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	if height < 0 || height >= len(bc.blocks) {
+		return nil, ErrBlockNotFound
+	}
+	return bc.blocks[height], nil
+}
+
+// LatestCommit returns the most recent beacon block committing shardID
+func (bc *BeaconChain) LatestCommit(shardID int) (*BeaconBlock, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	for i := len(bc.blocks) - 1; i >= 0; i-- {
+		if _, _, err := bc.blocks[i].ProveShard(shardID); err == nil {
+			return bc.blocks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: shard %d", ErrUnknownShard, shardID)
+}
+
+// Validate checks every beacon block's hash, shard root and linkage
+func (bc *BeaconChain) Validate() error {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	for i, b := range bc.blocks {
+		if b.Index != i {
+			return fmt.Errorf("beacon block %d: %w", i, ErrInvalidIndex)
+		}
+		if i > 0 && b.PrevHash != bc.blocks[i-1].Hash {
+			return fmt.Errorf("beacon block %d: %w", i, ErrInvalidPrevHash)
+		}
+		if b.ShardRoot != hex.EncodeToString(merkle.Root(shardLeaves(b.Shards))) {
+			return fmt.Errorf("beacon block %d: %w", i, ErrInvalidMerkleRoot)
+		}
+		if b.CalculateHash() != b.Hash {
+			return fmt.Errorf("beacon block %d: %w", i, ErrInvalidHash)
+		}
+	}
+	return nil
+}
+
+// CreateShardBlock creates a block of a shard chain. Shard transactions are
+// opaque payloads, so they travel in Data and MerkleRoot commits to them in
+// order; StateRoot commits to the shard's transaction root and membership
+// root after the block.
+func CreateShardBlock(index int, payloads [][]byte, prevHash string, root, membershipRoot []byte) Block {
+	block := Block{
+		Index:      index,
+		Timestamp:  time.Now().UTC(),
+		PrevHash:   prevHash,
+		MerkleRoot: hex.EncodeToString(merkle.Root(payloads)),
+		StateRoot:  ShardStateRoot(root, membershipRoot),
+		Data:       EncodePayloads(payloads),
+	}
+	block.Hash = block.CalculateHash()
+	return block
+}
+
+// ShardStateRoot returns the StateRoot of a shard block with the given
+// transaction and membership roots
+func ShardStateRoot(root, membershipRoot []byte) string {
+	return hex.EncodeToString(root) + "/" + hex.EncodeToString(membershipRoot)
+}
+
+// ParseShardStateRoot returns the roots a shard block's StateRoot commits to
+func ParseShardStateRoot(stateRoot string) (root, membershipRoot []byte, err error) {
+	rootHex, membershipHex, ok := strings.Cut(stateRoot, "/")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: shard state root %q", ErrNotShardBlock, stateRoot)
+	}
+	if root, err = hex.DecodeString(rootHex); err != nil {
+		return nil, nil, err
+	}
+	if membershipRoot, err = hex.DecodeString(membershipHex); err != nil {
+		return nil, nil, err
+	}
+	return root, membershipRoot, nil
+}
+
+// EncodePayloads returns the canonical encoding of a list of payloads
+func EncodePayloads(payloads [][]byte) []byte {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	e.uint32(uint32(len(payloads)))
+	for _, p := range payloads {
+		e.bytes(p)
+	}
+	return e.buf
+}
+
+// DecodePayloads decodes a list produced by EncodePayloads
+func DecodePayloads(data []byte) ([][]byte, error) {
+	d := &decoder{buf: data}
+	d.version()
+	count := d.uint32()
+	if d.err == nil && count > maxEncodedLength {
+		d.err = fmt.Errorf("payload count %d exceeds limit", count)
+	}
+	var payloads [][]byte
+	for i := uint32(0); d.err == nil && i < count; i++ {
+		payloads = append(payloads, d.bytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("failed to decode payloads: %w", err)
+	}
+	return payloads, nil
+}
+
+// ProvePayload returns a merkle proof of payload against a shard block's
+// MerkleRoot
+func ProvePayload(block *Block, payload []byte) ([]merkle.ProofStep, error) {
+	payloads, err := DecodePayloads(block.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotShardBlock, err)
+	}
+	for i, p := range payloads {
+		if string(p) == string(payload) {
+			return merkle.NewMerkleTree(payloads).GenerateProofAt(i)
+		}
+	}
+	return nil, fmt.Errorf("%w: shard block %d", ErrPayloadMissing, block.Index)
+}
+
+// ShardInclusionProof shows that a shard transaction is in a shard block
+// whose chain leads to a head committed by a beacon block
+type ShardInclusionProof struct {
+	Payload []byte
+	// TxProof proves Payload against the MerkleRoot of Headers[0]
+	TxProof []merkle.ProofStep
+	// Headers runs from the block holding Payload to the committed shard
+	// head, each linked to the one before by PrevHash
+	Headers []*Block
+	Head    ShardHead
+	// HeadProof proves Head against the beacon block's ShardRoot
+	HeadProof []merkle.ProofStep
+}
+
+// VerifyShardInclusion checks a shard inclusion proof against a beacon block
+func VerifyShardInclusion(beacon *BeaconBlock, proof *ShardInclusionProof) error {
+	if len(proof.Headers) == 0 {
+		return fmt.Errorf("%w: no shard block headers", ErrBadShardProof)
+	}
+	if err := VerifyShardHead(beacon, proof.Head, proof.HeadProof); err != nil {
+		return err
+	}
+
+	for i, header := range proof.Headers {
+		if header.CalculateHash() != header.Hash {
+			return fmt.Errorf("%w: shard block %d", ErrInvalidHash, header.Index)
+		}
+		if i > 0 && (header.PrevHash != proof.Headers[i-1].Hash || header.Index != proof.Headers[i-1].Index+1) {
+			return fmt.Errorf("%w: shard block %d does not extend %d", ErrBadShardProof, header.Index, proof.Headers[i-1].Index)
+		}
+	}
+	last := proof.Headers[len(proof.Headers)-1]
+	if last.Hash != proof.Head.Hash || last.Index != proof.Head.Height {
+		return fmt.Errorf("%w: header chain does not end at committed head", ErrBadShardProof)
+	}
+
+	root, err := hex.DecodeString(proof.Headers[0].MerkleRoot)
+	if err != nil || !merkle.VerifyProof(proof.Payload, proof.TxProof, root) {
+		return fmt.Errorf("%w: payload not under shard block %d", ErrBadShardProof, proof.Headers[0].Index)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBeaconCommitRejectsRegressedShard(t *testing.T) {
+	bc := NewBeaconChain()
+	if _, err := bc.Commit([]ShardHead{{ShardID: 1, Height: 3, Hash: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Commit([]ShardHead{{ShardID: 1, Height: 2, Hash: "b"}}); !errors.Is(err, ErrShardRegressed) {
+		t.Fatalf("lower height: got %v, want ErrShardRegressed", err)
+	}
+	if _, err := bc.Commit([]ShardHead{{ShardID: 1, Height: 3, Hash: "other"}}); !errors.Is(err, ErrShardRegressed) {
+		t.Fatalf("other block at the same height: got %v, want ErrShardRegressed", err)
+	}
+	if bc.Height() != 1 {
+		t.Fatalf("rejected commits moved the beacon to height %d", bc.Height())
+	}
+	if _, err := bc.Commit([]ShardHead{{ShardID: 1, Height: 3, Hash: "c"}, {ShardID: 2}}); err != nil {
+		t.Fatalf("unchanged head rejected: %v", err)
+	}
+}
@@ -1,9 +1,9 @@
 package core
 
 import (
+	"blockchain_A3/merkle"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"time"
 )
 
@@ -12,29 +12,19 @@ func Hash(data string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// GenerateMerkleRoot returns the hex merkle root over the canonical
+// encodings of the transactions, in block order
 func GenerateMerkleRoot(transactions []*Transaction) string {
-	if len(transactions) == 0 {
-		return Hash("")
-	}
-
-	var hashes []string
-	for _, tx := range transactions {
-		hashes = append(hashes, Hash(tx.Sender+tx.Receiver+fmt.Sprintf("%f", tx.Amount)))
-	}
+	return hex.EncodeToString(merkle.Root(TransactionLeaves(transactions)))
+}
 
-	for len(hashes) > 1 {
-		var newLevel []string
-		for i := 0; i < len(hashes); i += 2 {
-			if i+1 < len(hashes) {
-				newLevel = append(newLevel, Hash(hashes[i]+hashes[i+1]))
-			} else {
-				newLevel = append(newLevel, Hash(hashes[i]))
-			}
-		}
-		hashes = newLevel
+// TransactionLeaves returns the merkle leaf data for a block's transactions
+func TransactionLeaves(transactions []*Transaction) [][]byte {
+	leaves := make([][]byte, len(transactions))
+	for i, tx := range transactions {
+		leaves[i], _ = tx.MarshalBinary()
 	}
-
-	return hashes[0]
+	return leaves
 }
 
 type Block struct {
@@ -44,23 +34,34 @@ type Block struct {
 	PrevHash     string
 	Hash         string
 	MerkleRoot   string
+	StateRoot    string // world state root after applying Transactions
+	Bits         uint32 // compact proof-of-work target, zero if unsealed
+	Nonce        uint64 // proof-of-work solution
 	Data         []byte
 }
 
+// CalculateHash recomputes the hash of the block from its canonical header encoding
+func (b *Block) CalculateHash() string {
+	return Hash(string(b.HeaderBytes()))
+}
+
 func CreateBlock(index int, transactions []*Transaction, prevHash string) Block {
-	timestamp := time.Now()
-	merkleRoot := GenerateMerkleRoot(transactions)
-	blockData := fmt.Sprintf("%d%s%s%s", index, timestamp.String(), merkleRoot, prevHash)
-	hash := Hash(blockData)
+	return CreateBlockWithState(index, transactions, prevHash, "")
+}
 
-	return Block{
+// CreateBlockWithState creates a block that commits to the world state root
+// reached after executing its transactions
+func CreateBlockWithState(index int, transactions []*Transaction, prevHash, stateRoot string) Block {
+	block := Block{
 		Index:        index,
-		Timestamp:    timestamp,
+		Timestamp:    time.Now().UTC(),
 		Transactions: transactions,
 		PrevHash:     prevHash,
-		Hash:         hash,
-		MerkleRoot:   merkleRoot,
+		MerkleRoot:   GenerateMerkleRoot(transactions),
+		StateRoot:    stateRoot,
 	}
+	block.Hash = block.CalculateHash()
+	return block
 }
 
 func NewBlock(index int, data []byte) *Block {
@@ -1,39 +1,540 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+	"sync"
 )
 
+var (
+	ErrKnownBlock    = errors.New("block already known")
+	ErrUnknownParent = errors.New("parent block is unknown")
+)
+
+// DefaultChainID is used when Config.ChainID is empty
+const DefaultChainID = "blockchain-a3"
+
+// Config controls how a Blockchain is constructed
+type Config struct {
+	// Store holds the blocks of the chain. Defaults to an in-memory store.
+	Store BlockStore
+	// ForkChoice selects the canonical head. Defaults to LongestChainRule.
+	ForkChoice ForkChoiceRule
+	// State executes transactions and produces block state roots. Without
+	// one, blocks carry an empty StateRoot and balances are not checked.
+	State StateProcessor
+	// ChainID is the chain every transaction must be signed for, preventing
+	// replay on other chains. Defaults to DefaultChainID.
+	ChainID string
+	// Seal checks consensus fields such as proof-of-work. Without one,
+	// blocks are accepted regardless of how they were sealed.
+	Seal SealVerifier
+}
+
+// ChainReader gives consensus code read access to stored blocks
+type ChainReader interface {
+	GetBlockByHash(hash string) (*Block, error)
+}
+
+// SealVerifier checks the consensus-specific fields of a non-genesis block
+type SealVerifier interface {
+	VerifySeal(chain ChainReader, block, parent *Block) error
+}
+
+// StateProcessor executes transactions against the world state
+type StateProcessor interface {
+	// Apply executes transactions on top of the state identified by
+	// parentRoot and returns the resulting state root. An empty parentRoot
+	// denotes the genesis state.
+	Apply(parentRoot string, transactions []*Transaction) (string, error)
+}
+
+// ReorgEvent describes a change of canonical head that abandoned blocks.
+// Disconnected lists the old blocks from the old head down to the fork
+// point; Connected lists the new blocks from the fork point up to the new head.
+type ReorgEvent struct {
+	OldHead      string
+	NewHead      string
+	Disconnected []*Block
+	Connected    []*Block
+}
+
+// blockNode is the in-memory summary of a stored block in the block tree
+type blockNode struct {
+	hash      string
+	stateRoot string
+	parent    *blockNode
+	height    int
+	weight    *big.Int // cumulative fork-choice weight from genesis
+	seq       int      // arrival order, used to break ties
+}
+
+// ancestor returns the node's ancestor at height, or the node itself
+func (n *blockNode) ancestor(height int) *blockNode {
+	for n != nil && n.height > height {
+		n = n.parent
+	}
+	return n
+}
+
 type Blockchain struct {
-	Blocks []Block
+	mu        sync.RWMutex
+	store     BlockStore
+	rule      ForkChoiceRule
+	state     StateProcessor
+	chainID   string
+	seal      SealVerifier
+	nodes     map[string]*blockNode
+	order     []*blockNode // nodes in arrival order; parents precede children
+	tips      map[string]*blockNode
+	canonical []*blockNode // canonical chain indexed by height
+	// included maps each transaction hash to the nodes whose blocks hold it
+	included  map[string][]*blockNode
+	head      *Block
+	onReorg   []func(ReorgEvent)
+	onConnect []func(*Block)
 }
 
+// NewBlockchain creates an in-memory blockchain containing only the genesis block
 func NewBlockchain() *Blockchain {
-	genesis := CreateBlock(0, []*Transaction{}, "0")
-	return &Blockchain{
-		Blocks: []Block{genesis},
+	bc, err := NewBlockchainWithConfig(Config{})
+	if err != nil {
+		// The in-memory store cannot fail
+		panic(err)
 	}
+	return bc
 }
 
-func (bc *Blockchain) AddBlock(transactions []*Transaction) {
-	lastBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := CreateBlock(lastBlock.Index+1, transactions, lastBlock.Hash)
-	bc.Blocks = append(bc.Blocks, newBlock)
+// NewBlockchainWithConfig opens the chain held in cfg.Store, writing a genesis
+// block first if the store is empty
+func NewBlockchainWithConfig(cfg Config) (*Blockchain, error) {
+	store := cfg.Store
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	rule := cfg.ForkChoice
+	if rule == nil {
+		rule = LongestChainRule{}
+	}
+	chainID := cfg.ChainID
+	if chainID == "" {
+		chainID = DefaultChainID
+	}
+
+	bc := &Blockchain{
+		store:    store,
+		rule:     rule,
+		state:    cfg.State,
+		chainID:  chainID,
+		seal:     cfg.Seal,
+		nodes:    make(map[string]*blockNode),
+		tips:     make(map[string]*blockNode),
+		included: make(map[string][]*blockNode),
+	}
+
+	if store.Len() == 0 {
+		stateRoot := ""
+		if bc.state != nil {
+			root, err := bc.state.Apply("", nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build genesis state: %v", err)
+			}
+			stateRoot = root
+		}
+		genesis := CreateBlockWithState(0, []*Transaction{}, "0", stateRoot)
+		if err := store.Append(&genesis); err != nil {
+			return nil, fmt.Errorf("failed to store genesis block: %v", err)
+		}
+	}
+	if err := bc.loadTree(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// loadTree rebuilds the block tree from the store, height by height,
+// re-executing every block when a StateProcessor is configured
+func (bc *Blockchain) loadTree() error {
+	for height := 0; ; height++ {
+		blocks, err := bc.store.GetBlocksAtHeight(height)
+		if err != nil {
+			return fmt.Errorf("failed to load blocks at height %d: %v", height, err)
+		}
+		if len(blocks) == 0 {
+			break
+		}
+
+		for _, block := range blocks {
+			parent := bc.nodes[block.PrevHash]
+			if height > 0 && parent == nil {
+				return fmt.Errorf("block %d (%s): %w", block.Index, block.Hash, ErrUnknownParent)
+			}
+			if height == 0 && len(bc.nodes) > 0 {
+				// Only the first genesis block is part of the tree
+				continue
+			}
+			parentRoot := ""
+			if parent != nil {
+				parentRoot = parent.stateRoot
+			}
+			if err := bc.verifyTransactions(block, bc.replayedOn(parent)); err != nil {
+				return err
+			}
+			if err := bc.verifyState(block, parentRoot); err != nil {
+				return err
+			}
+			if parent != nil {
+				parentBlock, err := bc.store.GetBlockByHash(parent.hash)
+				if err != nil {
+					return fmt.Errorf("failed to load parent block: %v", err)
+				}
+				if err := bc.verifySeal(block, parentBlock); err != nil {
+					return err
+				}
+			}
+			bc.insertNode(block, parent)
+		}
+	}
+
+	if len(bc.order) == 0 {
+		return fmt.Errorf("block store has no genesis block")
+	}
+	_, err := bc.selectHead(false)
+	return err
+}
+
+// insertNode adds a block to the tree and updates the set of tips
+func (bc *Blockchain) insertNode(block *Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		hash:      block.Hash,
+		stateRoot: block.StateRoot,
+		parent:    parent,
+		height:    block.Index,
+		weight:    new(big.Int).Set(bc.rule.BlockWeight(block)),
+		seq:       len(bc.order),
+	}
+	if parent != nil {
+		node.weight.Add(node.weight, parent.weight)
+		delete(bc.tips, parent.hash)
+	}
+	bc.nodes[node.hash] = node
+	bc.order = append(bc.order, node)
+	bc.tips[node.hash] = node
+	for _, tx := range block.Transactions {
+		hash := tx.Hash()
+		bc.included[hash] = append(bc.included[hash], node)
+	}
+	return node
+}
+
+// replayedOn returns a check for whether a transaction is already included
+// in parent or one of its ancestors, so a replay is caught on any fork
+func (bc *Blockchain) replayedOn(parent *blockNode) func(hash string) bool {
+	return func(hash string) bool {
+		for _, n := range bc.included[hash] {
+			if parent.ancestor(n.height) == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// better reports whether a is preferred over b as the canonical head
+func better(a, b *blockNode) bool {
+	if cmp := a.weight.Cmp(b.weight); cmp != 0 {
+		return cmp > 0
+	}
+	if a.height != b.height {
+		return a.height > b.height
+	}
+	return a.seq < b.seq
+}
+
+// headChange collects what happened to the canonical chain during one update
+type headChange struct {
+	reorg     *ReorgEvent
+	connected []*Block // newly canonical blocks, lowest first
+}
+
+// selectHead picks the best tip and makes it the canonical head. When collect
+// is set it loads the newly connected blocks, and the abandoned ones if the
+// change was a reorg, so handlers can be notified.
+func (bc *Blockchain) selectHead(collect bool) (*headChange, error) {
+	var best *blockNode
+	for _, tip := range bc.tips {
+		if best == nil || better(tip, best) {
+			best = tip
+		}
+	}
+	if len(bc.canonical) > 0 && bc.canonical[len(bc.canonical)-1] == best {
+		return nil, nil
+	}
+
+	// Find the fork point between the current canonical chain and the new head
+	var connected []*blockNode
+	fork := best
+	for fork != nil && (fork.height >= len(bc.canonical) || bc.canonical[fork.height] != fork) {
+		connected = append(connected, fork)
+		fork = fork.parent
+	}
+
+	change := &headChange{}
+	forkHeight := -1
+	if fork != nil {
+		forkHeight = fork.height
+	}
+	if collect && len(bc.canonical) > 0 && forkHeight < len(bc.canonical)-1 {
+		change.reorg = &ReorgEvent{
+			OldHead: bc.canonical[len(bc.canonical)-1].hash,
+			NewHead: best.hash,
+		}
+		for i := len(bc.canonical) - 1; i > forkHeight; i-- {
+			block, err := bc.store.GetBlockByHash(bc.canonical[i].hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load disconnected block: %v", err)
+			}
+			change.reorg.Disconnected = append(change.reorg.Disconnected, block)
+		}
+	}
+
+	bc.canonical = bc.canonical[:forkHeight+1]
+	for i := len(connected) - 1; i >= 0; i-- {
+		bc.canonical = append(bc.canonical, connected[i])
+		if collect {
+			block, err := bc.store.GetBlockByHash(connected[i].hash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load connected block: %v", err)
+			}
+			change.connected = append(change.connected, block)
+		}
+	}
+	if change.reorg != nil {
+		change.reorg.Connected = change.connected
+	}
+
+	head, err := bc.store.GetBlockByHash(best.hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain head: %v", err)
+	}
+	bc.head = head
+	return change, nil
+}
+
+// OnReorg registers a handler that is called after every reorganisation
+func (bc *Blockchain) OnReorg(handler func(ReorgEvent)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.onReorg = append(bc.onReorg, handler)
+}
+
+// OnConnect registers a handler that is called for every block that becomes
+// part of the canonical chain, including blocks connected by a reorg
+func (bc *Blockchain) OnConnect(handler func(*Block)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.onConnect = append(bc.onConnect, handler)
+}
+
+// emit notifies handlers of a head change, reorg handlers first. It must be
+// called without holding bc.mu.
+func (bc *Blockchain) emit(change *headChange) {
+	if change == nil {
+		return
+	}
+	bc.mu.RLock()
+	reorgHandlers := append([]func(ReorgEvent){}, bc.onReorg...)
+	connectHandlers := append([]func(*Block){}, bc.onConnect...)
+	bc.mu.RUnlock()
+
+	if change.reorg != nil {
+		for _, handler := range reorgHandlers {
+			handler(*change.reorg)
+		}
+	}
+	for _, block := range change.connected {
+		for _, handler := range connectHandlers {
+			handler(block)
+		}
+	}
+}
+
+// PrepareBlock builds an unsealed candidate block of transactions on top of
+// the current head, executing them to fill in the state root. It fails if any
+// transaction is unsigned, mis-signed or replayed.
+func (bc *Blockchain) PrepareBlock(transactions []*Transaction) (*Block, error) {
+	bc.mu.RLock()
+	lastBlock := bc.head
+	probe := &Block{Index: lastBlock.Index + 1, Transactions: transactions}
+	err := bc.verifyTransactions(probe, bc.replayedOn(bc.nodes[lastBlock.Hash]))
+	bc.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	stateRoot := ""
+	if bc.state != nil {
+		root, err := bc.state.Apply(lastBlock.StateRoot, transactions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute transactions: %w", err)
+		}
+		stateRoot = root
+	}
+	newBlock := CreateBlockWithState(lastBlock.Index+1, transactions, lastBlock.Hash, stateRoot)
+	return &newBlock, nil
+}
+
+// AddBlock creates a block of transactions on top of the current head and
+// commits it
+func (bc *Blockchain) AddBlock(transactions []*Transaction) (*Block, error) {
+	newBlock, err := bc.PrepareBlock(transactions)
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.AcceptBlock(newBlock); err != nil {
+		return nil, err
+	}
 	fmt.Println("Block added:", newBlock.Index)
+	return newBlock, nil
+}
+
+// AcceptBlock validates a block whose parent is already known, stores it and
+// re-runs fork choice. The block may extend any tip or start a new fork.
+func (bc *Blockchain) AcceptBlock(block *Block) error {
+	change, err := bc.acceptBlock(block)
+	if err != nil {
+		return err
+	}
+	bc.emit(change)
+	return nil
+}
+
+func (bc *Blockchain) acceptBlock(block *Block) (*headChange, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if _, known := bc.nodes[block.Hash]; known {
+		return nil, &ValidationError{Index: block.Index, Hash: block.Hash, Err: ErrKnownBlock}
+	}
+	parentNode := bc.nodes[block.PrevHash]
+	if parentNode == nil {
+		return nil, &ValidationError{Index: block.Index, Hash: block.Hash, Err: ErrUnknownParent}
+	}
+	parent, err := bc.store.GetBlockByHash(parentNode.hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parent block: %v", err)
+	}
+	if err := ValidateBlock(block, parent); err != nil {
+		return nil, err
+	}
+	if err := bc.verifySeal(block, parent); err != nil {
+		return nil, err
+	}
+	if err := bc.verifyTransactions(block, bc.replayedOn(parentNode)); err != nil {
+		return nil, err
+	}
+	if err := bc.verifyState(block, parent.StateRoot); err != nil {
+		return nil, err
+	}
+
+	if err := bc.store.Append(block); err != nil {
+		return nil, fmt.Errorf("failed to store block %d: %v", block.Index, err)
+	}
+	bc.insertNode(block, parentNode)
+	return bc.selectHead(true)
+}
+
+// ReevaluateForkChoice recomputes every cumulative weight with the configured
+// rule and re-selects the head. Rules whose weights change over time, such as
+// ReputationVoteRule, need this after new votes arrive.
+func (bc *Blockchain) ReevaluateForkChoice() error {
+	change, err := bc.reevaluate()
+	if err != nil {
+		return err
+	}
+	bc.emit(change)
+	return nil
+}
+
+func (bc *Blockchain) reevaluate() (*headChange, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	for _, node := range bc.order {
+		block, err := bc.store.GetBlockByHash(node.hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load block %s: %v", node.hash, err)
+		}
+		node.weight = new(big.Int).Set(bc.rule.BlockWeight(block))
+		if node.parent != nil {
+			node.weight.Add(node.weight, node.parent.weight)
+		}
+	}
+	return bc.selectHead(true)
+}
+
+// Tips returns the hashes of every block that has no known children
+func (bc *Blockchain) Tips() []string {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	tips := make([]string, 0, len(bc.tips))
+	for hash := range bc.tips {
+		tips = append(tips, hash)
+	}
+	return tips
+}
+
+// LastBlock returns the block at the canonical head
+func (bc *Blockchain) LastBlock() *Block {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.head
+}
+
+// Height returns the index of the canonical head
+func (bc *Blockchain) Height() int {
+	return bc.LastBlock().Index
+}
+
+// GetBlockByHeight returns the canonical block at the given height
+func (bc *Blockchain) GetBlockByHeight(height int) (*Block, error) {
+	bc.mu.RLock()
+	if height < 0 || height >= len(bc.canonical) {
+		bc.mu.RUnlock()
+		return nil, ErrBlockNotFound
+	}
+	hash := bc.canonical[height].hash
+	bc.mu.RUnlock()
+	return bc.store.GetBlockByHash(hash)
+}
+
+// GetBlockByHash returns any known block, canonical or not, by its hash
+func (bc *Blockchain) GetBlockByHash(hash string) (*Block, error) {
+	return bc.store.GetBlockByHash(hash)
+}
+
+// ChainID returns the chain identifier transactions must be signed for
+func (bc *Blockchain) ChainID() string {
+	return bc.chainID
+}
+
+// Close releases the underlying block store
+func (bc *Blockchain) Close() error {
+	return bc.store.Close()
 }
 
 func (bc *Blockchain) GetMerkleRoot() []byte {
-	if len(bc.Blocks) == 0 {
+	head := bc.LastBlock()
+	if head == nil {
 		return []byte{}
 	}
-	lastBlock := bc.Blocks[len(bc.Blocks)-1]
-	return []byte(lastBlock.MerkleRoot)
+	return []byte(head.MerkleRoot)
 }
 
 func (bc *Blockchain) VerifyMerkleProof(rootHash []byte) bool {
-	if len(bc.Blocks) == 0 {
+	head := bc.LastBlock()
+	if head == nil {
 		return false
 	}
-	lastBlock := bc.Blocks[len(bc.Blocks)-1]
-	return string(rootHash) == lastBlock.MerkleRoot
+	return string(rootHash) == head.MerkleRoot
 }
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EncodingVersion is written as the first byte of every encoded block and
+// transaction. Decoders reject versions they do not understand.
+const EncodingVersion byte = 1
+
+// Encoding domains keep the bytes of one kind of object from ever being
+// valid as another
+const (
+	domainTxSigning   = "tx-sign"
+	domainBlockHeader = "block-header"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported encoding version")
+	ErrTruncated          = errors.New("encoded data is truncated")
+	ErrTrailingBytes      = errors.New("unexpected bytes after encoded value")
+)
+
+// maxEncodedLength bounds any single length prefix read by the decoder
+const maxEncodedLength = 64 << 20
+
+// The canonical encoding uses fixed-width big-endian integers and prefixes
+// every variable-length field with its uint32 length, so every value has
+// exactly one encoding and no two values share one.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(v byte) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) int64(v int64) {
+	e.uint64(uint64(v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+// decoder reads values written by encoder. The first error sticks and every
+// later read returns a zero value, so callers check err once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = ErrTruncated
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) int64() int64 {
+	return int64(d.uint64())
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err == nil && n > maxEncodedLength {
+		d.err = fmt.Errorf("field length %d exceeds limit", n)
+		return nil
+	}
+	b := d.take(int(n))
+	// nil and empty encode alike, and decode as nil
+	if len(b) == 0 {
+		return nil
+	}
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) version() {
+	if v := d.uint8(); d.err == nil && v != EncodingVersion {
+		d.err = fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+}
+
+// finish reports the first decoding error, or trailing unread bytes
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buf) != 0 {
+		return ErrTrailingBytes
+	}
+	return nil
+}
+
+func (tx *Transaction) encodeFields(e *encoder) {
+	e.string(tx.ChainID)
+	e.string(tx.Sender)
+	e.string(tx.Receiver)
+	e.int64(int64(tx.Amount))
+	e.int64(int64(tx.Fee))
+	e.uint64(tx.Nonce)
+	e.int64(tx.Timestamp)
+	e.bytes(tx.PublicKey)
+}
+
+// MarshalBinary returns the canonical encoding of the transaction
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	tx.encodeFields(e)
+	e.bytes(tx.Signature)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a transaction produced by MarshalBinary
+func (tx *Transaction) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	d.version()
+	decoded := Transaction{
+		ChainID:   d.string(),
+		Sender:    d.string(),
+		Receiver:  d.string(),
+		Amount:    Amount(d.int64()),
+		Fee:       Amount(d.int64()),
+		Nonce:     d.uint64(),
+		Timestamp: d.int64(),
+		PublicKey: d.bytes(),
+		Signature: d.bytes(),
+	}
+	if err := d.finish(); err != nil {
+		return fmt.Errorf("failed to decode transaction: %w", err)
+	}
+	*tx = decoded
+	return nil
+}
+
+// SigningBytes returns the canonical encoding of every field except the
+// signature, under a domain tag distinct from any other encoding
+func (tx *Transaction) SigningBytes() []byte {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	e.string(domainTxSigning)
+	tx.encodeFields(e)
+	return e.buf
+}
+
+func (b *Block) encodeHeader(e *encoder) {
+	e.int64(int64(b.Index))
+	e.int64(b.Timestamp.UnixNano())
+	e.string(b.PrevHash)
+	e.string(b.MerkleRoot)
+	e.string(b.StateRoot)
+	e.uint32(b.Bits)
+	e.uint64(b.Nonce)
+}
+
+// HeaderBytes returns the canonical encoding of the fields covered by the
+// block hash
+func (b *Block) HeaderBytes() []byte {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	e.string(domainBlockHeader)
+	b.encodeHeader(e)
+	return e.buf
+}
+
+// MarshalBinary returns the canonical encoding of the whole block
+func (b *Block) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.uint8(EncodingVersion)
+	b.encodeHeader(e)
+	e.string(b.Hash)
+	e.uint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		encoded, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		e.bytes(encoded)
+	}
+	e.bytes(b.Data)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a block produced by MarshalBinary
+func (b *Block) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	d.version()
+	decoded := Block{
+		Index:      int(d.int64()),
+		Timestamp:  time.Unix(0, d.int64()).UTC(),
+		PrevHash:   d.string(),
+		MerkleRoot: d.string(),
+		StateRoot:  d.string(),
+		Bits:       d.uint32(),
+		Nonce:      d.uint64(),
+		Hash:       d.string(),
+	}
+
+	count := d.uint32()
+	if d.err == nil && count > maxEncodedLength {
+		d.err = fmt.Errorf("transaction count %d exceeds limit", count)
+	}
+	for i := uint32(0); d.err == nil && i < count; i++ {
+		tx := &Transaction{}
+		if err := tx.UnmarshalBinary(d.bytes()); err != nil && d.err == nil {
+			d.err = fmt.Errorf("transaction %d: %w", i, err)
+		}
+		decoded.Transactions = append(decoded.Transactions, tx)
+	}
+	if data := d.bytes(); len(data) > 0 {
+		decoded.Data = data
+	}
+
+	if err := d.finish(); err != nil {
+		return fmt.Errorf("failed to decode block: %w", err)
+	}
+	*b = decoded
+	return nil
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// goldenKey is a fixed signing key, so signatures in the vectors are stable
+var goldenKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+
+func goldenTransactions() (unsigned, signed, coinbase *Transaction) {
+	unsigned = NewTransaction("alice", "bob", 5*Coin, 3)
+	unsigned.ChainID = "golden"
+	unsigned.Fee = 1000
+	unsigned.Timestamp = 1700000000
+
+	signed = NewSignedTransaction(goldenKey, "golden", "carol", Coin/2, 10, 0)
+	signed.Timestamp = 1700000001
+	signed.Sign(goldenKey)
+
+	coinbase = NewCoinbaseTransaction("golden", "miner", 50*Coin, 1)
+	return unsigned, signed, coinbase
+}
+
+func goldenBlock() *Block {
+	unsigned, signed, coinbase := goldenTransactions()
+	b := CreateBlockWithState(1, []*Transaction{coinbase, signed, unsigned}, "prev", "state")
+	b.Timestamp = time.Unix(1700000000, 123456789).UTC()
+	b.Bits = 0x1d00ffff
+	b.Nonce = 42
+	b.Data = []byte("seal")
+	b.Hash = b.CalculateHash()
+	return &b
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	unsigned, signed, coinbase := goldenTransactions()
+	empty := NewTransaction("a", "b", 1, 0)
+	empty.PublicKey, empty.Signature = []byte{}, []byte{}
+
+	for name, tx := range map[string]*Transaction{
+		"unsigned": unsigned, "signed": signed, "coinbase": coinbase, "empty key": empty,
+	} {
+		encoded, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Transaction
+		if err := decoded.UnmarshalBinary(encoded); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		reencoded, _ := decoded.MarshalBinary()
+		if !bytes.Equal(encoded, reencoded) {
+			t.Errorf("%s: re-encoding differs", name)
+		}
+		if len(tx.PublicKey) == 0 {
+			// nil and empty share an encoding and decode as nil
+			if decoded.PublicKey != nil || decoded.Signature != nil {
+				t.Errorf("%s: empty key and signature decoded as %#v, %#v", name, decoded.PublicKey, decoded.Signature)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(&decoded, tx) {
+			t.Errorf("%s: decoded %+v, want %+v", name, decoded, *tx)
+		}
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	block := goldenBlock()
+	encoded, err := block.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Block
+	if err := decoded.UnmarshalBinary(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, block) {
+		t.Fatalf("decoded %+v, want %+v", decoded, *block)
+	}
+	if decoded.CalculateHash() != block.Hash {
+		t.Fatal("decoded block hashes differently")
+	}
+}
+
+func TestEncodingGoldenVectors(t *testing.T) {
+	unsigned, signed, coinbase := goldenTransactions()
+	block := goldenBlock()
+	encode := func(m interface{ MarshalBinary() ([]byte, error) }) []byte {
+		b, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	vectors := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"unsigned transaction", encode(unsigned), goldenUnsignedTx},
+		{"signed transaction", encode(signed), goldenSignedTx},
+		{"coinbase transaction", encode(coinbase), goldenCoinbaseTx},
+		{"transaction signing bytes", unsigned.SigningBytes(), goldenSigningBytes},
+		{"block header", block.HeaderBytes(), goldenHeader},
+		{"block", encode(block), goldenBlockEncoding},
+	}
+	for _, v := range vectors {
+		if got := hex.EncodeToString(v.got); got != v.want {
+			t.Errorf("%s:\n got %s\nwant %s", v.name, got, v.want)
+		}
+	}
+	if block.Hash != goldenBlockHash {
+		t.Errorf("block hash %s, want %s", block.Hash, goldenBlockHash)
+	}
+}
+
+func TestDecodeRejectsMalformedInput(t *testing.T) {
+	unsigned, _, _ := goldenTransactions()
+	encoded, _ := unsigned.MarshalBinary()
+
+	var tx Transaction
+	if err := tx.UnmarshalBinary(encoded[:len(encoded)-1]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated: got %v", err)
+	}
+	if err := tx.UnmarshalBinary(append(encoded, 0)); !errors.Is(err, ErrTrailingBytes) {
+		t.Errorf("trailing byte: got %v", err)
+	}
+	bad := append([]byte{EncodingVersion + 1}, encoded[1:]...)
+	if err := tx.UnmarshalBinary(bad); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("unknown version: got %v", err)
+	}
+}
+
+// Vectors for the goldenTransactions and goldenBlock encodings, version 1
+const (
+	goldenUnsignedTx = "" +
+		"0100000006676f6c64656e00000005616c69636500000003626f62000000001dcd650000000000000003e80000000000" +
+		"000003000000006553f1000000000000000000"
+	goldenSignedTx = "" +
+		"0100000006676f6c64656e00000028666538313263313266336162346365366163356462363961633335326639303663" +
+		"62316231316566000000056361726f6c0000000002faf080000000000000000a0000000000000000000000006553f101" +
+		"00000020ea4a6c63e29c520abef5507b132ec5f9954776aebebe7b92421eea691446d22c000000403546a13120517071" +
+		"3193eb9dcb00d5b499e054afa7a40c3177f6190e587d8dbff1989418fc5a4f5c2c97910fc3fde62aae5af784bd9487aa" +
+		"7a180e8277223a0d"
+	goldenCoinbaseTx = "" +
+		"0100000006676f6c64656e00000000000000056d696e6572000000012a05f20000000000000000000000000000000001" +
+		"00000000000000000000000000000000"
+	goldenSigningBytes = "" +
+		"010000000774782d7369676e00000006676f6c64656e00000005616c69636500000003626f62000000001dcd65000000" +
+		"0000000003e80000000000000003000000006553f10000000000"
+	goldenHeader = "" +
+		"010000000c626c6f636b2d686561646572000000000000000117979cfe3d85cd15000000047072657600000040636561" +
+		"306432623831303166316231616564336337623531343965353336323166646466313238353132663261366337393239" +
+		"666462323165346531613265330000000573746174651d00ffff000000000000002a"
+	goldenBlockEncoding = "" +
+		"01000000000000000117979cfe3d85cd1500000004707265760000004063656130643262383130316631623161656433" +
+		"633762353134396535333632316664646631323835313266326136633739323966646232316534653161326533000000" +
+		"0573746174651d00ffff000000000000002a000000403533666632666433326335326334363333666534386236333862" +
+		"663963386231343161623962626464663063646330656666306263393136343464343639613200000003000000400100" +
+		"000006676f6c64656e00000000000000056d696e6572000000012a05f200000000000000000000000000000000010000" +
+		"0000000000000000000000000000000000c80100000006676f6c64656e00000028666538313263313266336162346365" +
+		"36616335646236396163333532663930366362316231316566000000056361726f6c0000000002faf080000000000000" +
+		"000a0000000000000000000000006553f10100000020ea4a6c63e29c520abef5507b132ec5f9954776aebebe7b92421e" +
+		"ea691446d22c000000403546a131205170713193eb9dcb00d5b499e054afa7a40c3177f6190e587d8dbff1989418fc5a" +
+		"4f5c2c97910fc3fde62aae5af784bd9487aa7a180e8277223a0d000000430100000006676f6c64656e00000005616c69" +
+		"636500000003626f62000000001dcd650000000000000003e80000000000000003000000006553f10000000000000000" +
+		"00000000047365616c"
+	goldenBlockHash = "53ff2fd32c52c4633fe48b638bf9c8b141ab9bbddf0cdc0eff0bc91644d469a2"
+)
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// Each record on disk is laid out as
+//
+//	[4 byte big-endian payload length][4 byte CRC-32 of payload][payload]
+//
+// Records are only ever appended, so a torn write can only cut the last
+// record short: its header, or the payload its valid length declares, runs
+// out at the end of the file. Such a record is truncated away when the store
+// is reopened; any other bad record is corruption and the store refuses to
+// open.
+const recordHeaderSize = 8
+
+var ErrCorruptStore = errors.New("block store is corrupt")
+
+// maxRecordSize guards against allocating huge buffers for a corrupt header
+const maxRecordSize = 64 << 20
+
+// FileStore is an append-only, file-backed BlockStore. Only the record offsets
+// are kept in memory; blocks are read from disk on demand.
+type FileStore struct {
+	mu       sync.RWMutex
+	file     *os.File
+	count    int
+	byHash   map[string]int64 // block hash -> record offset
+	byHeight map[int][]int64  // block index -> record offsets
+	size     int64            // offset of the next record
+}
+
+// OpenFileStore opens the block file at path, creating it if needed, and
+// rebuilds the height and hash indexes from its records
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block store: %v", err)
+	}
+
+	s := &FileStore{
+		file:     file,
+		byHash:   make(map[string]int64),
+		byHeight: make(map[int][]int64),
+	}
+	if err := s.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover scans every record, indexing the intact ones and truncating a
+// torn final record
+func (s *FileStore) recover() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat block store: %v", err)
+	}
+
+	var offset int64
+	for offset < info.Size() {
+		block, next, err := s.readRecord(offset)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorruptStore, err)
+		}
+		s.index(block, offset)
+		offset = next
+	}
+
+	if offset < info.Size() {
+		if err := s.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate torn record: %v", err)
+		}
+	}
+	s.size = offset
+	return nil
+}
+
+// readRecord decodes the record at offset and returns the offset of the next
+// one. A record cut short by the end of the file, as a torn write leaves it,
+// gives io.ErrUnexpectedEOF.
+func (s *FileStore) readRecord(offset int64) (*Block, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := s.file.ReadAt(header[:], offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return nil, 0, fmt.Errorf("record at offset %d is too large: %d bytes", offset, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := s.file.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, fmt.Errorf("checksum mismatch for record at offset %d", offset)
+	}
+
+	var block Block
+	if err := block.UnmarshalBinary(payload); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode block at offset %d: %v", offset, err)
+	}
+	return &block, offset + recordHeaderSize + int64(length), nil
+}
+
+func (s *FileStore) Append(block *Block) error {
+	payload, err := block.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to encode block %d: %v", block.Index, err)
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		return fmt.Errorf("failed to write block %d: %v", block.Index, err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block %d: %v", block.Index, err)
+	}
+
+	s.index(block, s.size)
+	s.size += int64(len(record))
+	return nil
+}
+
+// index records the offset of a block in the hash and height indexes
+func (s *FileStore) index(block *Block, offset int64) {
+	s.byHash[block.Hash] = offset
+	s.byHeight[block.Index] = append(s.byHeight[block.Index], offset)
+	s.count++
+}
+
+func (s *FileStore) GetBlocksAtHeight(height int) ([]*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	blocks := make([]*Block, 0, len(s.byHeight[height]))
+	for _, offset := range s.byHeight[height] {
+		block, _, err := s.readRecord(offset)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func (s *FileStore) GetBlockByHash(hash string) (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offset, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	block, _, err := s.readRecord(offset)
+	return block, err
+}
+
+func (s *FileStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.count
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
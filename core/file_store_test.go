@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeStore appends n linked blocks to a new store at path and returns the
+// record offsets
+func writeStore(t *testing.T, path string, n int) []int64 {
+	t.Helper()
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var offsets []int64
+	prev := "0"
+	for i := 0; i < n; i++ {
+		block := CreateBlock(i, nil, prev)
+		offsets = append(offsets, s.size)
+		if err := s.Append(&block); err != nil {
+			t.Fatal(err)
+		}
+		prev = block.Hash
+	}
+	return offsets
+}
+
+func TestFileStoreTruncatesTornFinalRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.dat")
+	writeStore(t, path, 3)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a header promising more payload than was written
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 3 {
+		t.Fatalf("recovered %d blocks, want 3", s.Len())
+	}
+	if s.size != info.Size() {
+		t.Fatalf("store size %d, want the %d bytes before the torn write", s.size, info.Size())
+	}
+}
+
+func TestFileStoreRefusesMidFileCorruption(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		at   func(offsets []int64) int64 // byte to corrupt
+	}{
+		{"payload", func(offsets []int64) int64 { return offsets[1] + recordHeaderSize + 2 }},
+		// a length no Append writes, pointing past the end of the file
+		{"length", func(offsets []int64) int64 { return offsets[1] }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "blocks.dat")
+			offsets := writeStore(t, path, 5)
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			corrupt := append([]byte(nil), before...)
+			corrupt[tc.at(offsets)] ^= 0xff
+			if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := OpenFileStore(path); !errors.Is(err, ErrCorruptStore) {
+				t.Fatalf("got %v, want ErrCorruptStore", err)
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(before) {
+				t.Fatalf("store was truncated from %d to %d bytes", len(before), len(after))
+			}
+		})
+	}
+}
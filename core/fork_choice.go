@@ -0,0 +1,66 @@
+package core
+
+import (
+	"blockchain_A3/bft"
+	"math/big"
+	"sync"
+)
+
+// ForkChoiceRule decides how much a block adds to the weight of the chain it
+// extends. The canonical head is the tip with the greatest cumulative weight;
+// ties go to the higher tip and then to the tip seen first.
+type ForkChoiceRule interface {
+	BlockWeight(block *Block) *big.Int
+}
+
+// LongestChainRule weighs every block equally, selecting the longest chain
+type LongestChainRule struct{}
+
+func (LongestChainRule) BlockWeight(block *Block) *big.Int {
+	return big.NewInt(1)
+}
+
+// MostWorkRule weighs each block by the proof-of-work its target represents,
+// selecting the chain with the most cumulative work
+type MostWorkRule struct{}
+
+func (MostWorkRule) BlockWeight(block *Block) *big.Int {
+	return block.Work()
+}
+
+// reputationScale converts fractional reputation into integer weight
+const reputationScale = 1000000
+
+// ReputationVoteRule weighs each block by the summed reputation of the
+// validators that voted for it. After recording new votes, call
+// Blockchain.ReevaluateForkChoice so the head reflects them.
+type ReputationVoteRule struct {
+	mu    sync.RWMutex
+	votes map[string]map[string]float64 // block hash -> validator ID -> reputation
+}
+
+// NewReputationVoteRule creates a vote-weighted fork-choice rule with no votes
+func NewReputationVoteRule() *ReputationVoteRule {
+	return &ReputationVoteRule{votes: make(map[string]map[string]float64)}
+}
+
+// Vote records a validator's vote for a block, weighted by its current
+// reputation. A repeated vote from the same validator replaces the earlier one.
+func (r *ReputationVoteRule) Vote(blockHash string, node *bft.Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.votes[blockHash] == nil {
+		r.votes[blockHash] = make(map[string]float64)
+	}
+	r.votes[blockHash][node.ID] = node.GetNodeReputation()
+}
+
+func (r *ReputationVoteRule) BlockWeight(block *Block) *big.Int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var total float64
+	for _, reputation := range r.votes[block.Hash] {
+		total += reputation
+	}
+	return big.NewInt(int64(total * reputationScale))
+}
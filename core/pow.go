@@ -0,0 +1,86 @@
+package core
+
+import (
+	"encoding/hex"
+	"math/big"
+)
+
+// Targets are stored in block headers in the compact "bits" form used by
+// Bitcoin: the high byte is a base-256 exponent and the low three bytes are
+// the mantissa, so target = mantissa * 256^(exponent-3).
+
+// CompactToBig expands compact bits into the full target
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		return target.Rsh(target, 8*(3-exponent))
+	}
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact encodes a target in compact form, truncating it to the
+// precision of a three byte mantissa
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	exponent := uint((target.BitLen() + 7) / 8)
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - exponent)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+
+	// The top mantissa bit is a sign bit, so shift into the next exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | mantissa
+}
+
+// oneLsh256 is 2^256, one more than the largest possible hash
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// WorkForBits returns the expected number of hashes needed to find a hash at
+// or below the target encoded by bits
+func WorkForBits(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+// Work returns the proof-of-work the block represents; blocks without a
+// target represent none
+func (b *Block) Work() *big.Int {
+	if b.Bits == 0 {
+		return big.NewInt(0)
+	}
+	return WorkForBits(b.Bits)
+}
+
+// MeetsTarget reports whether the block hash, read as a big-endian number,
+// is at or below the target encoded in the block's bits
+func (b *Block) MeetsTarget() bool {
+	if b.Bits == 0 {
+		return false
+	}
+	hash, err := hex.DecodeString(b.Hash)
+	if err != nil {
+		return false
+	}
+	return HashMeetsTarget(hash, CompactToBig(b.Bits))
+}
+
+// HashMeetsTarget reports whether a raw hash is at or below target
+func HashMeetsTarget(hash []byte, target *big.Int) bool {
+	return new(big.Int).SetBytes(hash).Cmp(target) <= 0
+}
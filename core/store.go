@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// BlockStore persists every known block, including blocks on side chains,
+// and serves them by height or hash
+type BlockStore interface {
+	// Append stores a block. Blocks must be appended after their parent.
+	Append(block *Block) error
+	// GetBlocksAtHeight returns every stored block with the given index, in
+	// the order they were appended
+	GetBlocksAtHeight(height int) ([]*Block, error)
+	// GetBlockByHash returns the block with the given hash
+	GetBlockByHash(hash string) (*Block, error)
+	// Len returns the number of stored blocks
+	Len() int
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// MemoryStore is a BlockStore that keeps every block in memory
+type MemoryStore struct {
+	mu       sync.RWMutex
+	count    int
+	byHash   map[string]*Block
+	byHeight map[int][]*Block
+}
+
+// NewMemoryStore creates an empty in-memory block store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		byHash:   make(map[string]*Block),
+		byHeight: make(map[int][]*Block),
+	}
+}
+
+func (s *MemoryStore) Append(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byHash[block.Hash] = block
+	s.byHeight[block.Index] = append(s.byHeight[block.Index], block)
+	s.count++
+	return nil
+}
+
+func (s *MemoryStore) GetBlocksAtHeight(height int) ([]*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	blocks := make([]*Block, len(s.byHeight[height]))
+	copy(blocks, s.byHeight[height])
+	return blocks, nil
+}
+
+func (s *MemoryStore) GetBlockByHash(hash string) (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	block, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return block, nil
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.count
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
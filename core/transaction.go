@@ -1,12 +1,121 @@
 package core
 
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsignedTransaction = errors.New("transaction is not signed")
+	ErrInvalidSignature    = errors.New("transaction signature is invalid")
+	ErrSenderMismatch      = errors.New("sender does not match public key")
+	ErrWrongChain          = errors.New("transaction is for a different chain")
+)
+
+// Amount is a fixed-point token amount counted in indivisible base units
+type Amount int64
+
+// Coin is the number of base units in one whole token
+const Coin Amount = 100000000
+
+// CoinbaseSender is the sender of coinbase transactions, which have no signer
+const CoinbaseSender = ""
+
+// String formats the amount in whole tokens with all eight decimals
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+	return fmt.Sprintf("%s%d.%08d", sign, a/Coin, a%Coin)
+}
+
 type Transaction struct {
+	ChainID   string
 	Sender    string
 	Receiver  string
-	Amount    float64
+	Amount    Amount
+	Fee       Amount // paid by Sender on top of Amount
+	Nonce     uint64 // must equal the number of transactions previously sent by Sender
 	Timestamp int64
+	PublicKey []byte // Ed25519 key whose address is Sender
+	Signature []byte // Ed25519 signature over SigningBytes
+}
+
+func NewTransaction(sender, receiver string, amount Amount, nonce uint64) *Transaction {
+	return &Transaction{Sender: sender, Receiver: receiver, Amount: amount, Nonce: nonce}
+}
+
+// NewSignedTransaction creates a transfer from the address of priv's public
+// key and signs it for the given chain
+func NewSignedTransaction(priv ed25519.PrivateKey, chainID, receiver string, amount, fee Amount, nonce uint64) *Transaction {
+	pub := priv.Public().(ed25519.PublicKey)
+	tx := NewTransaction(AddressFromPublicKey(pub), receiver, amount, nonce)
+	tx.ChainID = chainID
+	tx.Fee = fee
+	tx.Sign(priv)
+	return tx
+}
+
+// NewCoinbaseTransaction creates the unsigned transaction that pays the block
+// reward and fees to a block's proposer. The nonce is set to the block height
+// so coinbases of different blocks never share a hash.
+func NewCoinbaseTransaction(chainID, proposer string, amount Amount, height int) *Transaction {
+	return &Transaction{
+		ChainID:  chainID,
+		Sender:   CoinbaseSender,
+		Receiver: proposer,
+		Amount:   amount,
+		Nonce:    uint64(height),
+	}
+}
+
+// IsCoinbase reports whether the transaction mints the block reward
+func (tx *Transaction) IsCoinbase() bool {
+	return tx.Sender == CoinbaseSender && len(tx.PublicKey) == 0
+}
+
+// AddressFromPublicKey derives an account address from an Ed25519 public key
+func AddressFromPublicKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:20])
+}
+
+// Sign attaches priv's public key and a signature over the transaction
+func (tx *Transaction) Sign(priv ed25519.PrivateKey) {
+	tx.PublicKey = priv.Public().(ed25519.PublicKey)
+	tx.Signature = ed25519.Sign(priv, tx.SigningBytes())
+}
+
+// VerifySignature checks that the transaction is signed for chainID by the
+// key that owns its Sender address
+func (tx *Transaction) VerifySignature(chainID string) error {
+	if len(tx.PublicKey) == 0 || len(tx.Signature) == 0 {
+		return ErrUnsignedTransaction
+	}
+	if tx.ChainID != chainID {
+		return fmt.Errorf("%w: got %q, want %q", ErrWrongChain, tx.ChainID, chainID)
+	}
+	if len(tx.PublicKey) != ed25519.PublicKeySize {
+		return ErrInvalidSignature
+	}
+	if AddressFromPublicKey(tx.PublicKey) != tx.Sender {
+		return ErrSenderMismatch
+	}
+	if !ed25519.Verify(tx.PublicKey, tx.SigningBytes(), tx.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
 }
 
-func NewTransaction(sender, receiver string, amount int) *Transaction {
-	return &Transaction{Sender: sender, Receiver: receiver, Amount: float64(amount)}
+// Hash identifies the transaction by the SHA-256 of its canonical encoding,
+// including its signature
+func (tx *Transaction) Hash() string {
+	encoded, _ := tx.MarshalBinary()
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
 }
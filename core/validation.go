@@ -0,0 +1,167 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrEmptyChain        = errors.New("blockchain has no blocks")
+	ErrInvalidIndex      = errors.New("block index is not sequential")
+	ErrInvalidPrevHash   = errors.New("previous hash does not match parent block")
+	ErrInvalidMerkleRoot = errors.New("merkle root does not match transactions")
+	ErrInvalidHash       = errors.New("block hash does not match contents")
+	ErrInvalidStateRoot  = errors.New("state root does not match executed transactions")
+	ErrDuplicateTx       = errors.New("transaction appears more than once in block")
+	ErrReplayedTx        = errors.New("transaction is already included in an ancestor block")
+	ErrMisplacedCoinbase = errors.New("coinbase transaction must be the first in its block")
+	ErrInvalidSeal       = errors.New("block seal is invalid")
+)
+
+// ValidationError reports the first block that failed chain validation
+type ValidationError struct {
+	Index int
+	Hash  string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("block %d (%s): %v", e.Index, e.Hash, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateBlock checks a single block against its parent. A nil parent means
+// the block is treated as the genesis block.
+func ValidateBlock(block, parent *Block) error {
+	fail := func(err error) error {
+		return &ValidationError{Index: block.Index, Hash: block.Hash, Err: err}
+	}
+
+	if parent == nil {
+		if block.Index != 0 {
+			return fail(ErrInvalidIndex)
+		}
+	} else {
+		if block.Index != parent.Index+1 {
+			return fail(ErrInvalidIndex)
+		}
+		if block.PrevHash != parent.Hash {
+			return fail(ErrInvalidPrevHash)
+		}
+	}
+
+	if GenerateMerkleRoot(block.Transactions) != block.MerkleRoot {
+		return fail(ErrInvalidMerkleRoot)
+	}
+	if block.CalculateHash() != block.Hash {
+		return fail(ErrInvalidHash)
+	}
+	return nil
+}
+
+// Validate walks the canonical chain from genesis and returns a *ValidationError
+// describing the first block that is inconsistent, or nil if the chain is intact.
+func (bc *Blockchain) Validate() error {
+	height := bc.Height()
+	if height < 0 {
+		return ErrEmptyChain
+	}
+
+	var parent *Block
+	included := make(map[string]struct{})
+	for i := 0; i <= height; i++ {
+		block, err := bc.GetBlockByHeight(i)
+		if err != nil {
+			return &ValidationError{Index: i, Err: err}
+		}
+		if err := ValidateBlock(block, parent); err != nil {
+			return err
+		}
+		parentRoot := ""
+		if parent != nil {
+			parentRoot = parent.StateRoot
+		}
+		if parent != nil {
+			if err := bc.verifySeal(block, parent); err != nil {
+				return err
+			}
+		}
+		err = bc.verifyTransactions(block, func(hash string) bool {
+			_, ok := included[hash]
+			return ok
+		})
+		if err != nil {
+			return err
+		}
+		if err := bc.verifyState(block, parentRoot); err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			included[tx.Hash()] = struct{}{}
+		}
+		parent = block
+	}
+	return nil
+}
+
+// verifyTransactions checks that every transaction in a block is correctly
+// signed for this chain and appears only once, and that only the first
+// transaction is a coinbase. replayed reports whether a transaction hash is
+// already included in one of the block's ancestors.
+func (bc *Blockchain) verifyTransactions(block *Block, replayed func(hash string) bool) error {
+	seen := make(map[string]struct{}, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		var err error
+		switch {
+		case tx.IsCoinbase() && i != 0:
+			err = ErrMisplacedCoinbase
+		case tx.IsCoinbase() && tx.ChainID != bc.chainID:
+			err = ErrWrongChain
+		case !tx.IsCoinbase():
+			err = tx.VerifySignature(bc.chainID)
+		}
+		if err != nil {
+			return &ValidationError{Index: block.Index, Hash: block.Hash, Err: fmt.Errorf("transaction %d: %w", i, err)}
+		}
+		hash := tx.Hash()
+		if _, dup := seen[hash]; dup {
+			return &ValidationError{Index: block.Index, Hash: block.Hash, Err: fmt.Errorf("transaction %d: %w", i, ErrDuplicateTx)}
+		}
+		if replayed(hash) {
+			return &ValidationError{Index: block.Index, Hash: block.Hash, Err: fmt.Errorf("transaction %d: %w", i, ErrReplayedTx)}
+		}
+		seen[hash] = struct{}{}
+	}
+	return nil
+}
+
+// verifyState re-executes a block's transactions on top of its parent's state
+// and checks the resulting root. It is a no-op without a StateProcessor.
+func (bc *Blockchain) verifyState(block *Block, parentRoot string) error {
+	if bc.state == nil {
+		return nil
+	}
+	root, err := bc.state.Apply(parentRoot, block.Transactions)
+	if err != nil {
+		return &ValidationError{Index: block.Index, Hash: block.Hash, Err: err}
+	}
+	if root != block.StateRoot {
+		return &ValidationError{Index: block.Index, Hash: block.Hash, Err: ErrInvalidStateRoot}
+	}
+	return nil
+}
+
+// verifySeal checks a block's consensus fields with the configured
+// SealVerifier. It is a no-op without one.
+func (bc *Blockchain) verifySeal(block, parent *Block) error {
+	if bc.seal == nil {
+		return nil
+	}
+	if err := bc.seal.VerifySeal(bc.store, block, parent); err != nil {
+		return &ValidationError{Index: block.Index, Hash: block.Hash, Err: fmt.Errorf("%w: %v", ErrInvalidSeal, err)}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestAddBlockRejectsReplayedTransaction(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockchain()
+	tx := NewSignedTransaction(priv, bc.ChainID(), "bob", Coin, 0, 0)
+
+	genesis := bc.LastBlock()
+	first, err := bc.AddBlock([]*Transaction{tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock([]*Transaction{tx}); !errors.Is(err, ErrReplayedTx) {
+		t.Fatalf("replay in a later block: got %v, want ErrReplayedTx", err)
+	}
+
+	// a competing block at the same height may include it
+	fork := CreateBlock(1, []*Transaction{tx}, genesis.Hash)
+	fork.Nonce = 1
+	fork.Hash = fork.CalculateHash()
+	if fork.Hash == first.Hash {
+		t.Fatal("fork block matches the canonical block")
+	}
+	if err := bc.AcceptBlock(&fork); err != nil {
+		t.Fatalf("sibling block rejected: %v", err)
+	}
+	if err := bc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"blockchain_A3/core"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 )
 
 func Hash(data string) string {
@@ -12,27 +11,11 @@ func Hash(data string) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// GenerateMerkleRoot returns the same root as core.GenerateMerkleRoot
 func GenerateMerkleRoot(transactions []core.Transaction) string {
-	if len(transactions) == 0 {
-		return Hash("")
+	txs := make([]*core.Transaction, len(transactions))
+	for i := range transactions {
+		txs[i] = &transactions[i]
 	}
-
-	var hashes []string
-	for _, tx := range transactions {
-		hashes = append(hashes, Hash(tx.Sender+tx.Receiver+fmt.Sprintf("%f", tx.Amount)))
-	}
-
-	for len(hashes) > 1 {
-		var newLevel []string
-		for i := 0; i < len(hashes); i += 2 {
-			if i+1 < len(hashes) {
-				newLevel = append(newLevel, Hash(hashes[i]+hashes[i+1]))
-			} else {
-				newLevel = append(newLevel, Hash(hashes[i]))
-			}
-		}
-		hashes = newLevel
-	}
-
-	return hashes[0]
+	return core.GenerateMerkleRoot(txs)
 }
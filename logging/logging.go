@@ -0,0 +1,55 @@
+// Package logging provides the structured logger used by the merkle, amf
+// and sync packages. Nothing is logged unless a logger is injected.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+)
+
+// Attribute keys shared by every package that logs
+const (
+	KeyShard = "shard"
+	KeyTx    = "tx"
+	KeyRoot  = "root"
+)
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discard = slog.New(discardHandler{})
+
+// Discard returns a logger that drops every record
+func Discard() *slog.Logger {
+	return discard
+}
+
+// OrDiscard returns l, or the discarding logger if l is nil
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discard
+	}
+	return l
+}
+
+// Shard identifies a shard
+func Shard(id int) slog.Attr {
+	return slog.Int(KeyShard, id)
+}
+
+// TxHash identifies a transaction by the hex SHA-256 of its bytes
+func TxHash(tx []byte) slog.Attr {
+	sum := sha256.Sum256(tx)
+	return slog.String(KeyTx, hex.EncodeToString(sum[:]))
+}
+
+// Root identifies a Merkle root
+func Root(root []byte) slog.Attr {
+	return slog.String(KeyRoot, hex.EncodeToString(root))
+}
@@ -3,11 +3,22 @@ package main
 import (
 	"blockchain_A3/amf"
 	"blockchain_A3/bft"
+	"blockchain_A3/consensus"
 	"blockchain_A3/core"
+	"blockchain_A3/mempool"
+	"blockchain_A3/merkle"
+	"blockchain_A3/producer"
+	"blockchain_A3/state"
 	"blockchain_A3/sync"
 	"blockchain_A3/verification"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
+	"log/slog"
 	"math/big"
+	"os"
+	"time"
 )
 
 func main() {
@@ -35,15 +46,116 @@ func main() {
 	fmt.Printf("Current Consistency Level: %s\n", getConsistencyLevelName(co.ConsistencyLevel))
 	fmt.Printf("Timeout: %ds, Retries: %d\n", 5, 3)
 
-	// Create new blockchain
-	bc := core.NewBlockchain()
+	// Generate account keys; addresses are derived from the public keys
+	names := []string{"Alice", "Bob", "Charlie"}
+	keys := make(map[string]ed25519.PrivateKey)
+	addresses := make(map[string]string)
+	for _, name := range names {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fmt.Println("Error generating account key:", err)
+			return
+		}
+		keys[name] = priv
+		addresses[name] = core.AddressFromPublicKey(pub)
+	}
+
+	// Create new blockchain backed by an account state with genesis balances,
+	// sealed by proof-of-work and following the chain with the most work
+	blockReward := 1 * core.Coin
+	stateDB, err := state.NewStateDB(state.GenesisAlloc{
+		addresses["Alice"]: 10 * core.Coin,
+	}, blockReward)
+	if err != nil {
+		fmt.Println("Error creating state:", err)
+		return
+	}
+	powEngine := consensus.NewPoWEngine(consensus.DefaultPoWConfig())
+	bc, err := core.NewBlockchainWithConfig(core.Config{
+		State:      stateDB,
+		Seal:       powEngine,
+		ForkChoice: core.MostWorkRule{},
+	})
+	if err != nil {
+		fmt.Println("Error creating blockchain:", err)
+		return
+	}
+
+	// Mine blocks from the mempool, paying the reward and fees to Charlie
+	pool := mempool.New(mempool.DefaultConfig(bc.ChainID()))
+	pool.Watch(bc)
+	blockProducer := producer.New(bc, pool, powEngine, producer.DefaultConfig(addresses["Charlie"], blockReward))
+	mine := func(txs ...*core.Transaction) {
+		for _, tx := range txs {
+			if err := pool.Add(tx); err != nil {
+				fmt.Printf("Error adding transaction to mempool: %v\n", err)
+			}
+		}
+		block, err := blockProducer.ProduceBlock(context.Background())
+		if err != nil {
+			fmt.Printf("Error producing block: %v\n", err)
+			return
+		}
+		fmt.Printf("Mined block %d with %d transactions (nonce %d, bits %08x)\n",
+			block.Index, len(block.Transactions), block.Nonce, block.Bits)
+	}
+
+	// Add signed transactions
+	tx1 := core.NewSignedTransaction(keys["Alice"], bc.ChainID(), addresses["Bob"], 5*core.Coin, 0, 0)
+	tx2 := core.NewSignedTransaction(keys["Bob"], bc.ChainID(), addresses["Charlie"], 2*core.Coin, 0, 0)
+	mine(tx1, tx2)
 
-	// Add dummy transactions
-	tx1 := core.NewTransaction("Alice", "Bob", 5)
-	tx2 := core.NewTransaction("Bob", "Charlie", 2)
+	// Replaying an already included transaction must be refused
+	if _, err := bc.AddBlock([]*core.Transaction{tx1}); err != nil {
+		fmt.Printf("Replayed transaction rejected: %v\n", err)
+	}
+
+	mine(
+		core.NewSignedTransaction(keys["Alice"], bc.ChainID(), addresses["Charlie"], 1*core.Coin, core.Coin/100, 1),
+		core.NewSignedTransaction(keys["Bob"], bc.ChainID(), addresses["Alice"], 1*core.Coin, core.Coin/50, 1),
+	)
+
+	// Verify the chain has not been tampered with
+	if err := bc.Validate(); err != nil {
+		fmt.Printf("Blockchain validation failed: %v\n", err)
+	} else {
+		fmt.Println("Blockchain validation passed.")
+	}
+
+	// Show balances after the block
+	if ws, err := stateDB.StateAt(bc.LastBlock().StateRoot); err == nil {
+		for _, name := range names {
+			fmt.Printf("Balance of %s: %s\n", name, ws.GetAccount(addresses[name]).Balance)
+		}
+	}
+
+	// Prove what Alice's balance was after block 1
+	if accountProof, err := stateDB.AccountAt(bc, addresses["Alice"], 1); err == nil {
+		fmt.Printf("Balance of Alice at block 1: %s (proof of %d nodes, valid: %v)\n",
+			accountProof.Account.Balance, len(accountProof.Nodes), state.VerifyAccountProof(accountProof) == nil)
+	}
 
-	// Add block
-	bc.AddBlock([]*core.Transaction{tx1, tx2})
+	// Finalise blocks of a second chain with a PBFT validator committee whose
+	// first primary has crashed, forcing a view change
+	committee, err := consensus.NewCluster(4, 5*time.Second, 0, nil)
+	if err != nil {
+		fmt.Println("Error creating validator committee:", err)
+		return
+	}
+	committee.Replicas[0].SetFault(consensus.FaultSilent)
+	dbftEngine := consensus.NewDBFTEngine(committee)
+	bftChain, err := core.NewBlockchainWithConfig(core.Config{State: stateDB, Seal: dbftEngine})
+	if err != nil {
+		fmt.Println("Error creating PBFT chain:", err)
+		return
+	}
+	bftProducer := producer.New(bftChain, mempool.New(mempool.DefaultConfig(bftChain.ChainID())), dbftEngine,
+		producer.DefaultConfig(addresses["Charlie"], blockReward))
+	if block, err := bftProducer.ProduceBlock(context.Background()); err != nil {
+		fmt.Printf("Error finalising block with PBFT: %v\n", err)
+	} else {
+		fmt.Printf("PBFT committed block %d in view %d\n", block.Index, committee.Primary().View())
+	}
 
 	fmt.Println("\nBlockchain created with genesis block.")
 
@@ -57,25 +169,37 @@ func main() {
 
 	tree := amf.NewMerkleTree(transactions)
 
-	fmt.Printf("\nMerkle Root: %x\n", tree.Root.Hash)
+	fmt.Printf("\nMerkle Root: %x\n", tree.RootHash())
 
 	// Generate Merkle Proof for first transaction
 	proof := tree.GenerateMerkleProof(0)
 
 	// Verify the proof
-	isValid := verification.VerifyMerkleProof(proof, amf.GetTransactionHash(transactions[0]), tree.Root.Hash)
+	isValid := verification.VerifyMerkleProof(proof, amf.GetTransactionHash(transactions[0]), tree.RootHash())
 	fmt.Println("Merkle Proof Valid:", isValid)
 
+	// Prove both transactions at once with a multi-proof shipped in binary form
+	multiProof, err := tree.GenerateMultiProof([]int{0, 1})
+	if err == nil {
+		encoded, _ := multiProof.MarshalBinary()
+		var shipped merkle.MultiProof
+		if err := shipped.UnmarshalBinary(encoded); err == nil {
+			leafHashes := [][]byte{amf.GetTransactionHash(transactions[0]), amf.GetTransactionHash(transactions[1])}
+			fmt.Println("Merkle Multi-Proof Valid:", verification.BatchVerifyMerkleProof(&shipped, leafHashes, tree.RootHash()))
+		}
+	}
+
 	// ------------------------
 	// Shard Management
 	// ------------------------
 	fmt.Println("\nInitializing Shard Manager...")
-	manager := amf.NewShardManager()
+	manager := amf.NewShardManager(amf.DefaultShardPolicy())
+	manager.SetLogger(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})))
 
 	// Simulate adding load to shards
 	fmt.Println("\nAdding transactions to shards...")
 
-	// Add initial transactions (more than maxShardLoad to force split)
+	// Add initial transactions (more than the policy's max load to force split)
 	initialTransactions := []string{
 		"User0 -> User1: 0",
 		"User1 -> User2: 10",
@@ -166,8 +290,8 @@ func main() {
 		return
 	}
 
-	// Perform a cross-shard transfer
-	if err := sync.CrossShardTransfer(manager, 0, 1, 0); err != nil {
+	// Perform a cross-shard transfer between the first two shards by ID
+	if err := sync.CrossShardTransfer(manager, manager.Shards[0].ID, manager.Shards[1].ID, 0); err != nil {
 		fmt.Printf("Error in cross-shard transfer: %v\n", err)
 	}
 
@@ -175,6 +299,12 @@ func main() {
 	fmt.Println("\nFinal Shard States:")
 	manager.PrintShards()
 
+	fmt.Println("\nShard load metrics:")
+	for _, m := range manager.Metrics() {
+		fmt.Printf("Shard %d: score %.2f, %d txs, %d bytes, %.2f tps, %.0f%% cross-shard, %d hot keys\n",
+			m.ShardID, m.Score, m.Transactions, m.Bytes, m.TPS, m.CrossShardRatio*100, len(m.HotKeys))
+	}
+
 	// ------------------------
 	// AMQ Filter Membership Check
 	// ------------------------
@@ -227,6 +357,50 @@ func main() {
 		}
 		manager.PrintShards()
 	}
+
+	// ------------------------
+	// Shard Topology History
+	// ------------------------
+	fmt.Println("\n--- Shard topology history ---")
+	for _, change := range manager.Changes() {
+		if change.Proof == nil {
+			fmt.Println(change)
+			continue
+		}
+		fmt.Printf("%s, restructuring proof valid: %v\n", change, amf.VerifyRestructuring(change.Proof) == nil)
+		if change.Split != nil {
+			fmt.Printf("  predicted %s\n", change.Split)
+		}
+	}
+	traced := []byte(initialTransactions[0])
+	for epoch := uint64(0); epoch <= manager.Epoch(); epoch++ {
+		if id, err := manager.ShardAt(traced, epoch); err != nil {
+			fmt.Printf("Epoch %d: %q %v\n", epoch, traced, err)
+		} else {
+			fmt.Printf("Epoch %d: %q held by shard %d\n", epoch, traced, id)
+		}
+	}
+
+	// ------------------------
+	// Shard Chains and Beacon Commitments
+	// ------------------------
+	fmt.Println("\n--- Shard chains committed to the beacon chain ---")
+	beacon, err := manager.CommitBeacon()
+	if err != nil {
+		fmt.Printf("Error committing shard heads: %v\n", err)
+	} else {
+		for _, head := range beacon.Shards {
+			fmt.Printf("Beacon block %d commits shard %d at height %d (%s...)\n", beacon.Index, head.ShardID, head.Height, head.Hash[:16])
+		}
+		sample := manager.Shards[0].Transactions[0]
+		if proof, committed, err := manager.ProveTransaction(sample); err != nil {
+			fmt.Printf("Error proving shard transaction: %v\n", err)
+		} else {
+			fmt.Printf("%q is in shard %d block %d, committed by beacon block %d (valid: %v)\n",
+				sample, proof.Head.ShardID, proof.Headers[0].Index, committed.Index, core.VerifyShardInclusion(committed, proof) == nil)
+		}
+	}
+
 	// ------------------------
 	// Additional BFT Operations
 	// ------------------------
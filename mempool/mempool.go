@@ -0,0 +1,319 @@
+package mempool
+
+import (
+	"blockchain_A3/core"
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrAlreadyKnown  = errors.New("transaction already in pool")
+	ErrInvalidAmount = errors.New("transaction amount must be positive")
+	ErrFeeTooLow     = errors.New("transaction fee below pool minimum")
+	ErrTooLarge      = errors.New("transaction exceeds pool size limit")
+	ErrSenderLimit   = errors.New("sender has too many pending transactions")
+	ErrNonceConflict = errors.New("pending transaction with the same nonce pays at least as much fee")
+	ErrPoolFull      = errors.New("pool is full and fee is too low to evict another transaction")
+)
+
+// Config limits what the pool accepts
+type Config struct {
+	ChainID         string      // chain transactions must be signed for
+	MaxTransactions int         // maximum number of pending transactions
+	MaxBytes        int         // maximum total encoded size of pending transactions
+	MaxPerSender    int         // maximum pending transactions per sender
+	MinFee          core.Amount // minimum fee accepted
+}
+
+// DefaultConfig returns limits suitable for a single node
+func DefaultConfig(chainID string) Config {
+	return Config{
+		ChainID:         chainID,
+		MaxTransactions: 4096,
+		MaxBytes:        4 << 20,
+		MaxPerSender:    64,
+	}
+}
+
+// entry is a pending transaction with its bookkeeping
+type entry struct {
+	tx   *core.Transaction
+	hash string
+	size int
+	seq  uint64 // arrival order
+}
+
+// higherPriority orders entries by fee, then by arrival
+func higherPriority(a, b *entry) bool {
+	if a.tx.Fee != b.tx.Fee {
+		return a.tx.Fee > b.tx.Fee
+	}
+	return a.seq < b.seq
+}
+
+// Mempool holds validated transactions waiting to be included in a block
+type Mempool struct {
+	mu       sync.Mutex
+	cfg      Config
+	all      map[string]*entry
+	bySender map[string]map[uint64]*entry // sender -> nonce -> entry
+	bytes    int
+	seq      uint64
+}
+
+// New creates an empty pool
+func New(cfg Config) *Mempool {
+	return &Mempool{
+		cfg:      cfg,
+		all:      make(map[string]*entry),
+		bySender: make(map[string]map[uint64]*entry),
+	}
+}
+
+// Add validates a transaction and adds it to the pool. A transaction that
+// reuses a pending sender nonce replaces the pending one only if it pays a
+// higher fee. When the pool is full the lowest-priority transaction is
+// evicted if the new one pays more.
+func (mp *Mempool) Add(tx *core.Transaction) error {
+	if err := tx.VerifySignature(mp.cfg.ChainID); err != nil {
+		return err
+	}
+	if tx.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if tx.Fee < mp.cfg.MinFee {
+		return fmt.Errorf("%w: %s < %s", ErrFeeTooLow, tx.Fee, mp.cfg.MinFee)
+	}
+	encoded, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if mp.cfg.MaxBytes > 0 && len(encoded) > mp.cfg.MaxBytes {
+		return ErrTooLarge
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	hash := tx.Hash()
+	if _, exists := mp.all[hash]; exists {
+		return ErrAlreadyKnown
+	}
+
+	// removed holds what is dropped to make room, put back if there is
+	// still no room in the end
+	var removed []*entry
+	pending := mp.bySender[tx.Sender]
+	if existing, ok := pending[tx.Nonce]; ok {
+		if tx.Fee <= existing.tx.Fee {
+			return ErrNonceConflict
+		}
+		mp.remove(existing)
+		removed = append(removed, existing)
+	} else if mp.cfg.MaxPerSender > 0 && len(pending) >= mp.cfg.MaxPerSender {
+		return ErrSenderLimit
+	}
+
+	mp.seq++
+	e := &entry{tx: tx, hash: hash, size: len(encoded), seq: mp.seq}
+	for mp.full(e.size) {
+		victim := mp.evictionCandidate()
+		// Evicting our own predecessor would leave this transaction unminable
+		if victim == nil || !higherPriority(e, victim) ||
+			(victim.tx.Sender == tx.Sender && victim.tx.Nonce < tx.Nonce) {
+			for _, r := range removed {
+				mp.insert(r)
+			}
+			return ErrPoolFull
+		}
+		mp.remove(victim)
+		removed = append(removed, victim)
+	}
+
+	mp.insert(e)
+	return nil
+}
+
+// full reports whether adding size bytes would break a pool limit
+func (mp *Mempool) full(size int) bool {
+	if mp.cfg.MaxTransactions > 0 && len(mp.all) >= mp.cfg.MaxTransactions {
+		return true
+	}
+	return mp.cfg.MaxBytes > 0 && mp.bytes+size > mp.cfg.MaxBytes
+}
+
+// evictionCandidate returns the lowest-priority transaction that can be
+// dropped without stranding later nonces of the same sender
+func (mp *Mempool) evictionCandidate() *entry {
+	var victim *entry
+	for _, pending := range mp.bySender {
+		var last *entry
+		for _, e := range pending {
+			if last == nil || e.tx.Nonce > last.tx.Nonce {
+				last = e
+			}
+		}
+		if last != nil && (victim == nil || higherPriority(victim, last)) {
+			victim = last
+		}
+	}
+	return victim
+}
+
+func (mp *Mempool) insert(e *entry) {
+	mp.all[e.hash] = e
+	if mp.bySender[e.tx.Sender] == nil {
+		mp.bySender[e.tx.Sender] = make(map[uint64]*entry)
+	}
+	mp.bySender[e.tx.Sender][e.tx.Nonce] = e
+	mp.bytes += e.size
+}
+
+func (mp *Mempool) remove(e *entry) {
+	delete(mp.all, e.hash)
+	pending := mp.bySender[e.tx.Sender]
+	delete(pending, e.tx.Nonce)
+	if len(pending) == 0 {
+		delete(mp.bySender, e.tx.Sender)
+	}
+	mp.bytes -= e.size
+}
+
+// Remove drops a transaction by hash, reporting whether it was pending
+func (mp *Mempool) Remove(hash string) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	e, ok := mp.all[hash]
+	if ok {
+		mp.remove(e)
+	}
+	return ok
+}
+
+// Has reports whether a transaction is pending
+func (mp *Mempool) Has(hash string) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	_, ok := mp.all[hash]
+	return ok
+}
+
+// Len returns the number of pending transactions
+func (mp *Mempool) Len() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.all)
+}
+
+// Size returns the total encoded size of pending transactions
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.bytes
+}
+
+// senderQueue is one sender's pending transactions in nonce order
+type senderQueue []*entry
+
+// priorityQueue orders sender queues by the priority of their next transaction
+type priorityQueue []senderQueue
+
+func (pq priorityQueue) Len() int           { return len(pq) }
+func (pq priorityQueue) Less(i, j int) bool { return higherPriority(pq[i][0], pq[j][0]) }
+func (pq priorityQueue) Swap(i, j int)      { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *priorityQueue) Push(x any)        { *pq = append(*pq, x.(senderQueue)) }
+func (pq *priorityQueue) Pop() any {
+	old := *pq
+	q := old[len(old)-1]
+	*pq = old[:len(old)-1]
+	return q
+}
+
+// Pending returns up to limit transactions (all if limit <= 0) ordered by
+// fee and arrival time. Transactions from one sender always appear in nonce
+// order, and a gap in a sender's nonces hides the transactions after it.
+func (mp *Mempool) Pending(limit int) []*core.Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	pq := make(priorityQueue, 0, len(mp.bySender))
+	for _, pending := range mp.bySender {
+		queue := make(senderQueue, 0, len(pending))
+		for _, e := range pending {
+			queue = append(queue, e)
+		}
+		sort.Slice(queue, func(i, j int) bool { return queue[i].tx.Nonce < queue[j].tx.Nonce })
+		for i := 1; i < len(queue); i++ {
+			if queue[i].tx.Nonce != queue[i-1].tx.Nonce+1 {
+				queue = queue[:i]
+				break
+			}
+		}
+		pq = append(pq, queue)
+	}
+	heap.Init(&pq)
+
+	var txs []*core.Transaction
+	for pq.Len() > 0 && (limit <= 0 || len(txs) < limit) {
+		queue := pq[0]
+		txs = append(txs, queue[0].tx)
+		if len(queue) > 1 {
+			pq[0] = queue[1:]
+			heap.Fix(&pq, 0)
+		} else {
+			heap.Pop(&pq)
+		}
+	}
+	return txs
+}
+
+// RemoveBlock drops the transactions included in a block, along with any
+// pending transaction whose nonce the block has already consumed
+func (mp *Mempool) RemoveBlock(block *core.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range block.Transactions {
+		if e, ok := mp.all[tx.Hash()]; ok {
+			mp.remove(e)
+		}
+		for nonce, e := range mp.bySender[tx.Sender] {
+			if nonce <= tx.Nonce {
+				mp.remove(e)
+			}
+		}
+	}
+}
+
+// HandleReorg drops transactions included by the newly connected blocks and
+// re-injects transactions from disconnected blocks that the new chain did not
+// include. Re-injected transactions that no longer validate are discarded.
+func (mp *Mempool) HandleReorg(event core.ReorgEvent) {
+	included := make(map[string]struct{})
+	for _, block := range event.Connected {
+		mp.RemoveBlock(block)
+		for _, tx := range block.Transactions {
+			included[tx.Hash()] = struct{}{}
+		}
+	}
+
+	// Disconnected is ordered from the old head down, so walk it backwards
+	// to re-add each sender's transactions in nonce order
+	for i := len(event.Disconnected) - 1; i >= 0; i-- {
+		for _, tx := range event.Disconnected[i].Transactions {
+			if _, ok := included[tx.Hash()]; ok {
+				continue
+			}
+			_ = mp.Add(tx)
+		}
+	}
+}
+
+// Watch keeps the pool in step with a chain: included transactions are
+// removed as blocks connect, and orphaned ones are re-injected on reorgs
+func (mp *Mempool) Watch(bc *core.Blockchain) {
+	bc.OnReorg(mp.HandleReorg)
+	bc.OnConnect(mp.RemoveBlock)
+}
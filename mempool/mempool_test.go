@@ -0,0 +1,57 @@
+package mempool
+
+import (
+	"blockchain_A3/core"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func encodedSize(t *testing.T, tx *core.Transaction) int {
+	t.Helper()
+	b, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(b)
+}
+
+func TestFailedReplacementKeepsPendingTransaction(t *testing.T) {
+	const chainID = "test"
+	alice, bob := newKey(t), newKey(t)
+	original := core.NewSignedTransaction(alice, chainID, "carol", core.Coin, 2, 0)
+	other := core.NewSignedTransaction(bob, chainID, "carol", core.Coin, 100, 0)
+	// the replacement pays more but is too large to fit without evicting
+	// bob's better-paying transaction
+	replacement := core.NewSignedTransaction(alice, chainID, strings.Repeat("c", 64), core.Coin, 3, 0)
+
+	cfg := DefaultConfig(chainID)
+	cfg.MaxBytes = encodedSize(t, original) + encodedSize(t, other)
+	mp := New(cfg)
+	for _, tx := range []*core.Transaction{original, other} {
+		if err := mp.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mp.Add(replacement); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("got %v, want ErrPoolFull", err)
+	}
+	if !mp.Has(original.Hash()) || !mp.Has(other.Hash()) || mp.Has(replacement.Hash()) {
+		t.Fatal("failed replacement changed the pool")
+	}
+	if mp.Len() != 2 {
+		t.Fatalf("pool holds %d transactions, want 2", mp.Len())
+	}
+}
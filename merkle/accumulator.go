@@ -0,0 +1,201 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Accumulator is an append-only Merkle tree. It keeps the hash of every
+// complete, aligned subtree, so appending costs O(log n) and the root of
+// the first n leaves, for any n up to the current size, always equals the
+// root NewMerkleTree would build over those leaves.
+type Accumulator struct {
+	// nodes[h][i] is the hash of leaves [i*2^h, (i+1)*2^h)
+	nodes [][][]byte
+}
+
+func NewAccumulator() *Accumulator {
+	return &Accumulator{nodes: [][][]byte{{}}}
+}
+
+// Append adds a leaf and returns its index
+func (a *Accumulator) Append(data []byte) int {
+	return a.AppendHash(LeafHash(data))
+}
+
+// AppendHash adds a precomputed leaf hash and returns its index
+func (a *Accumulator) AppendHash(leafHash []byte) int {
+	index := len(a.nodes[0])
+	a.nodes[0] = append(a.nodes[0], leafHash)
+	for h := 0; len(a.nodes[h])%2 == 0; h++ {
+		if h+1 == len(a.nodes) {
+			a.nodes = append(a.nodes, nil)
+		}
+		level := a.nodes[h]
+		a.nodes[h+1] = append(a.nodes[h+1], NodeHash(level[len(level)-2], level[len(level)-1]))
+	}
+	return index
+}
+
+// Size returns the number of leaves
+func (a *Accumulator) Size() int {
+	return len(a.nodes[0])
+}
+
+// Leaf returns the hash of the leaf at index
+func (a *Accumulator) Leaf(index int) []byte {
+	return a.nodes[0][index]
+}
+
+// Root returns the root over all leaves
+func (a *Accumulator) Root() []byte {
+	if a.Size() == 0 {
+		return EmptyRoot()
+	}
+	return a.subtree(0, a.Size())
+}
+
+// RootAt returns the root the accumulator had when it held size leaves
+func (a *Accumulator) RootAt(size int) ([]byte, error) {
+	if size < 0 || size > a.Size() {
+		return nil, fmt.Errorf("size %d out of range [0, %d]", size, a.Size())
+	}
+	if size == 0 {
+		return EmptyRoot(), nil
+	}
+	return a.subtree(0, size), nil
+}
+
+// subtree returns the root over leaves [start, end). Ranges are always
+// those produced by splitting at the largest power of two, so the left
+// part is a complete subtree that can be looked up directly.
+func (a *Accumulator) subtree(start, end int) []byte {
+	n := end - start
+	if n&(n-1) == 0 {
+		h := log2(n)
+		return a.nodes[h][start>>h]
+	}
+	k := splitPoint(n)
+	return NodeHash(a.subtree(start, start+k), a.subtree(start+k, end))
+}
+
+// InclusionProof proves the leaf at index against the root of the first
+// size leaves
+func (a *Accumulator) InclusionProof(index, size int) ([]ProofStep, error) {
+	if size < 1 || size > a.Size() {
+		return nil, fmt.Errorf("size %d out of range [1, %d]", size, a.Size())
+	}
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, size)
+	}
+	return a.path(index, 0, size), nil
+}
+
+func (a *Accumulator) path(index, start, end int) []ProofStep {
+	if end-start == 1 {
+		return nil
+	}
+	k := splitPoint(end - start)
+	if index < start+k {
+		return append(a.path(index, start, start+k), ProofStep{Hash: a.subtree(start+k, end)})
+	}
+	return append(a.path(index, start+k, end), ProofStep{Hash: a.subtree(start, start+k), IsLeft: true})
+}
+
+// ConsistencyProof proves that the tree of the first oldSize leaves is a
+// prefix of the tree of the first newSize leaves
+func (a *Accumulator) ConsistencyProof(oldSize, newSize int) ([][]byte, error) {
+	if newSize > a.Size() || oldSize < 0 || oldSize > newSize {
+		return nil, fmt.Errorf("cannot prove sizes %d -> %d with %d leaves", oldSize, newSize, a.Size())
+	}
+	if oldSize == 0 || oldSize == newSize {
+		return nil, nil
+	}
+	return a.subproof(oldSize, 0, newSize, true), nil
+}
+
+func (a *Accumulator) subproof(m, start, end int, complete bool) [][]byte {
+	n := end - start
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{a.subtree(start, end)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(a.subproof(m, start, start+k, complete), a.subtree(start+k, end))
+	}
+	return append(a.subproof(m-k, start+k, end, false), a.subtree(start, start+k))
+}
+
+// VerifyConsistency checks a proof that newRoot's tree extends oldRoot's
+func VerifyConsistency(oldSize, newSize int, oldRoot, newRoot []byte, proof [][]byte) error {
+	switch {
+	case oldSize < 0 || oldSize > newSize:
+		return fmt.Errorf("%w: sizes %d -> %d", ErrMalformedProof, oldSize, newSize)
+	case oldSize == newSize:
+		if len(proof) != 0 || !bytes.Equal(oldRoot, newRoot) {
+			return fmt.Errorf("roots of equal size %d differ", oldSize)
+		}
+		return nil
+	case oldSize == 0:
+		if len(proof) != 0 {
+			return fmt.Errorf("%w: non-empty proof from the empty tree", ErrMalformedProof)
+		}
+		return nil
+	}
+
+	// walk the proof as described in RFC 9162 section 2.1.4.2
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return fmt.Errorf("%w: empty consistency proof", ErrMalformedProof)
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: consistency proof too long", ErrMalformedProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = NodeHash(c, fr)
+			sr = NodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = NodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, oldRoot) || !bytes.Equal(sr, newRoot) {
+		return fmt.Errorf("consistency proof does not link %x to %x", oldRoot, newRoot)
+	}
+	return nil
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func log2(n int) int {
+	h := 0
+	for n > 1 {
+		n >>= 1
+		h++
+	}
+	return h
+}
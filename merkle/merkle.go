@@ -1,20 +1,34 @@
+// Package merkle implements the Merkle tree construction shared by blocks,
+// shards and proofs.
+//
+// Leaves are hashed as SHA-256(0x00 || data) and inner nodes as
+// SHA-256(0x01 || left || right), so a leaf can never be passed off as an
+// inner node. Levels are paired left to right; an unpaired last node is
+// promoted to the next level unchanged. This yields the same roots as the
+// RFC 6962 Merkle Tree Hash. The empty tree hashes to SHA-256("").
 package merkle
 
 import (
+	"blockchain_A3/logging"
+	"bytes"
 	"crypto/sha256"
 	"fmt"
-	"sort"
+	"log/slog"
 )
 
-type Node struct {
-	Left  *Node
-	Right *Node
-	Hash  []byte
-}
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+
+	hashSize = sha256.Size
+)
 
+// MerkleTree stores every level of the tree as an array of hashes, so the
+// sibling of node i on any level is simply node i^1 and its parent is i/2
 type MerkleTree struct {
-	Root   *Node
-	Leaves []*Node
+	levels [][][]byte // levels[0] holds the leaf hashes, the last level the root
+	index  map[string]int
+	logger *slog.Logger
 }
 
 type ProofStep struct {
@@ -22,166 +36,181 @@ type ProofStep struct {
 	IsLeft bool // true if this hash should be on the left when combining
 }
 
-func NewMerkleTree(data [][]byte) *MerkleTree {
-	if len(data) == 0 {
-		return &MerkleTree{Root: &Node{Hash: hash([]byte{})}}
-	}
+// LeafHash returns the domain-separated hash of a leaf's data
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
 
-	// Sort the data to ensure consistent ordering
-	sortedData := make([][]byte, len(data))
-	copy(sortedData, data)
-	sort.Slice(sortedData, func(i, j int) bool {
-		return string(sortedData[i]) < string(sortedData[j])
-	})
+// NodeHash returns the domain-separated hash of two child hashes
+func NodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// EmptyRoot returns the root of a tree with no leaves
+func EmptyRoot() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
 
-	var leaves []*Node
-	for _, d := range sortedData {
-		leaves = append(leaves, &Node{Hash: hash(d)})
+// Root computes the root over data without keeping the tree
+func Root(data [][]byte) []byte {
+	if len(data) == 0 {
+		return EmptyRoot()
+	}
+	level := make([][]byte, len(data))
+	for i, d := range data {
+		level[i] = LeafHash(d)
+	}
+	for len(level) > 1 {
+		next := level[:0]
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, NodeHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
 	}
+	return level[0]
+}
 
-	root := buildTree(leaves)
-	return &MerkleTree{Root: root, Leaves: leaves}
+// NewMerkleTree builds a tree over data in the given order
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	leaves := make([][]byte, len(data))
+	for i, d := range data {
+		leaves[i] = LeafHash(d)
+	}
+	return NewMerkleTreeFromHashes(leaves)
 }
 
-func buildTree(nodes []*Node) *Node {
-	if len(nodes) == 1 {
-		return nodes[0]
+// NewMerkleTreeFromHashes builds a tree over precomputed leaf hashes
+func NewMerkleTreeFromHashes(leaves [][]byte) *MerkleTree {
+	mt := &MerkleTree{index: make(map[string]int, len(leaves)), logger: logging.Discard()}
+	for i, leaf := range leaves {
+		if _, ok := mt.index[string(leaf)]; !ok {
+			mt.index[string(leaf)] = i
+		}
+	}
+	if len(leaves) == 0 {
+		mt.levels = [][][]byte{{}, {EmptyRoot()}}
+		return mt
 	}
 
-	var newLevel []*Node
-	for i := 0; i < len(nodes); i += 2 {
-		if i+1 < len(nodes) {
-			// Use nodes in their original order
-			left, right := nodes[i], nodes[i+1]
-
-			// Always combine hashes in the same order (left.Hash followed by right.Hash)
-			combinedHash := make([]byte, len(left.Hash)+len(right.Hash))
-			copy(combinedHash, left.Hash)
-			copy(combinedHash[len(left.Hash):], right.Hash)
-
-			newNode := &Node{
-				Left:  left,
-				Right: right,
-				Hash:  hash(combinedHash),
-			}
-			newLevel = append(newLevel, newNode)
-		} else {
-			// For odd number of nodes, duplicate the last node
-			combinedHash := make([]byte, len(nodes[i].Hash)*2)
-			copy(combinedHash, nodes[i].Hash)
-			copy(combinedHash[len(nodes[i].Hash):], nodes[i].Hash)
-
-			newNode := &Node{
-				Left:  nodes[i],
-				Right: nodes[i],
-				Hash:  hash(combinedHash),
+	level := leaves
+	mt.levels = append(mt.levels, level)
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, NodeHash(level[i], level[i+1]))
+			} else {
+				// An unpaired node is promoted without rehashing
+				next = append(next, level[i])
 			}
-			newLevel = append(newLevel, newNode)
 		}
+		mt.levels = append(mt.levels, next)
+		level = next
 	}
+	return mt
+}
 
-	return buildTree(newLevel)
+// SetLogger sets the logger proof generation reports to; nil silences it
+func (mt *MerkleTree) SetLogger(l *slog.Logger) {
+	mt.logger = logging.OrDiscard(l)
 }
 
-func hash(data []byte) []byte {
-	h := sha256.New()
-	h.Write(data)
-	return h.Sum(nil)
+// RootHash returns the root of the tree
+func (mt *MerkleTree) RootHash() []byte {
+	top := mt.levels[len(mt.levels)-1]
+	return top[0]
 }
 
-func findParent(root, target *Node) *Node {
-	if root == nil || root == target {
-		return nil
-	}
+// Len returns the number of leaves
+func (mt *MerkleTree) Len() int {
+	return len(mt.levels[0])
+}
 
-	if root.Left == target || root.Right == target {
-		return root
-	}
+// Leaf returns the hash of the leaf at index
+func (mt *MerkleTree) Leaf(index int) []byte {
+	return mt.levels[0][index]
+}
 
-	if parent := findParent(root.Left, target); parent != nil {
-		return parent
-	}
+// Leaves returns the leaf hashes in order
+func (mt *MerkleTree) Leaves() [][]byte {
+	return append([][]byte{}, mt.levels[0]...)
+}
 
-	return findParent(root.Right, target)
+// IndexOf returns the index of the first leaf with the given hash
+func (mt *MerkleTree) IndexOf(leafHash []byte) (int, bool) {
+	i, ok := mt.index[string(leafHash)]
+	return i, ok
 }
 
+// GenerateProof proves the first leaf holding data
 func (mt *MerkleTree) GenerateProof(data []byte) ([]ProofStep, error) {
-	dataHash := hash(data)
-	var targetNode *Node
-	var targetIndex int
-	for i, leaf := range mt.Leaves {
-		if string(leaf.Hash) == string(dataHash) {
-			targetNode = leaf
-			targetIndex = i
-			break
-		}
-	}
-
-	if targetNode == nil {
+	i, ok := mt.IndexOf(LeafHash(data))
+	if !ok {
 		return nil, fmt.Errorf("data not found in tree")
 	}
+	proof, err := mt.GenerateProofAt(i)
+	if err != nil {
+		return nil, err
+	}
 
-	var proof []ProofStep
-	current := targetNode
-	parent := findParent(mt.Root, current)
-
-	for parent != nil {
-		// Determine if current node is left or right child
-		isLeft := parent.Left == current
+	mt.logger.Debug("generated merkle proof",
+		logging.TxHash(data), logging.Root(mt.RootHash()), slog.Int("index", i), slog.Int("steps", len(proof)))
+	return proof, nil
+}
 
-		if isLeft {
-			// When current is left child, we need the right sibling
-			if parent.Right != nil {
-				proof = append(proof, ProofStep{Hash: parent.Right.Hash, IsLeft: false})
-			} else {
-				// This shouldn't happen with our balanced tree
-				proof = append(proof, ProofStep{Hash: parent.Left.Hash, IsLeft: false})
-			}
-		} else {
-			// When current is right child, we need the left sibling
-			proof = append(proof, ProofStep{Hash: parent.Left.Hash, IsLeft: true})
-		}
-		current = parent
-		parent = findParent(mt.Root, current)
+// GenerateProofByHash proves the first leaf with the given hash
+func (mt *MerkleTree) GenerateProofByHash(leafHash []byte) ([]ProofStep, error) {
+	i, ok := mt.IndexOf(leafHash)
+	if !ok {
+		return nil, fmt.Errorf("leaf %x not found in tree", leafHash)
 	}
+	return mt.GenerateProofAt(i)
+}
 
-	// Print debug information about the proof
-	fmt.Printf("Debug - Generated proof for transaction: %s (index: %d)\n", string(data), targetIndex)
-	for i, step := range proof {
-		fmt.Printf("Debug - Proof[%d]: %x (IsLeft: %v)\n", i, step.Hash, step.IsLeft)
+// GenerateProofAt proves the leaf at index in O(log n)
+func (mt *MerkleTree) GenerateProofAt(index int) ([]ProofStep, error) {
+	if index < 0 || index >= mt.Len() {
+		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", index, mt.Len())
 	}
 
+	proof := make([]ProofStep, 0, len(mt.levels)-1)
+	for _, level := range mt.levels[:len(mt.levels)-1] {
+		sibling := index ^ 1
+		// a node without a sibling was promoted and adds no step
+		if sibling < len(level) {
+			proof = append(proof, ProofStep{Hash: level[sibling], IsLeft: sibling < index})
+		}
+		index /= 2
+	}
 	return proof, nil
 }
 
-func VerifyProof(data []byte, proof []ProofStep, rootHash []byte) bool {
-	currentHash := hash(data)
-	fmt.Printf("Debug - Initial hash: %x\n", currentHash)
-
-	for i, step := range proof {
-		fmt.Printf("Debug - Step %d - Current hash: %x\n", i, currentHash)
-		fmt.Printf("Debug - Step %d - Sibling hash: %x (IsLeft: %v)\n", i, step.Hash, step.IsLeft)
-
-		// Combine hashes based on the order specified in the proof
-		var combinedHash []byte
+// RootFromProof folds a proof over a leaf hash and returns the implied root
+func RootFromProof(leafHash []byte, proof []ProofStep) []byte {
+	current := leafHash
+	for _, step := range proof {
 		if step.IsLeft {
-			// If sibling is left, it goes first
-			combinedHash = make([]byte, len(step.Hash)+len(currentHash))
-			copy(combinedHash, step.Hash)
-			copy(combinedHash[len(step.Hash):], currentHash)
+			current = NodeHash(step.Hash, current)
 		} else {
-			// If sibling is right, current hash goes first
-			combinedHash = make([]byte, len(currentHash)+len(step.Hash))
-			copy(combinedHash, currentHash)
-			copy(combinedHash[len(currentHash):], step.Hash)
+			current = NodeHash(current, step.Hash)
 		}
-		currentHash = hash(combinedHash)
-
-		fmt.Printf("Debug - Step %d - Combined hash: %x\n", i, currentHash)
 	}
+	return current
+}
 
-	fmt.Printf("Debug - Final hash: %x\n", currentHash)
-	fmt.Printf("Debug - Expected root: %x\n", rootHash)
-
-	return string(currentHash) == string(rootHash)
+// VerifyProof checks that data is committed to by rootHash
+func VerifyProof(data []byte, proof []ProofStep, rootHash []byte) bool {
+	return bytes.Equal(RootFromProof(LeafHash(data), proof), rootHash)
 }
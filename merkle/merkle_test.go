@@ -0,0 +1,128 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// rfc6962Leaves are the leaf inputs of the RFC 6962 reference test vectors
+var rfc6962Leaves = [][]byte{
+	{},
+	{0x00},
+	{0x10},
+	{0x20, 0x21},
+	{0x30, 0x31},
+	{0x40, 0x41, 0x42, 0x43},
+	{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+	{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+}
+
+// rfc6962Roots[n] is the Merkle Tree Hash of the first n leaves
+var rfc6962Roots = []string{
+	"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func TestRFC6962Vectors(t *testing.T) {
+	for n, want := range rfc6962Roots {
+		data := rfc6962Leaves[:n]
+		if got := hex.EncodeToString(Root(data)); got != want {
+			t.Errorf("Root of %d leaves = %s, want %s", n, got, want)
+		}
+		if got := hex.EncodeToString(NewMerkleTree(data).RootHash()); got != want {
+			t.Errorf("tree root of %d leaves = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestRFC6962LeafAndNodeHashes(t *testing.T) {
+	l0, l1, l2 := LeafHash(rfc6962Leaves[0]), LeafHash(rfc6962Leaves[1]), LeafHash(rfc6962Leaves[2])
+	vectors := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"LeafHash of the empty leaf", l0, rfc6962Roots[1]},
+		{"NodeHash of leaves 0 and 1", NodeHash(l0, l1), rfc6962Roots[2]},
+		{"NodeHash of the 2-leaf root and leaf 2", NodeHash(NodeHash(l0, l1), l2), rfc6962Roots[3]},
+		{"EmptyRoot", EmptyRoot(), rfc6962Roots[0]},
+	}
+	for _, v := range vectors {
+		if got := hex.EncodeToString(v.got); got != v.want {
+			t.Errorf("%s = %s, want %s", v.name, got, v.want)
+		}
+	}
+}
+
+// TestConstructionsAgree checks every construction in the package against
+// the RFC 6962 roots for 0, 1, 2, 3, 5 and 7 leaves
+func TestConstructionsAgree(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 5, 7} {
+		data := rfc6962Leaves[:n]
+		want := rfc6962Roots[n]
+
+		acc := NewAccumulator()
+		for _, d := range data {
+			acc.Append(d)
+		}
+		if got := hex.EncodeToString(acc.Root()); got != want {
+			t.Errorf("accumulator root of %d leaves = %s, want %s", n, got, want)
+		}
+
+		tree := NewMerkleTree(data)
+		for i, d := range data {
+			proof, err := tree.GenerateProofAt(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyProof(d, proof, tree.RootHash()) {
+				t.Errorf("proof for leaf %d of %d does not verify", i, n)
+			}
+			if accProof, err := acc.InclusionProof(i, n); err != nil || hex.EncodeToString(RootFromProof(LeafHash(d), accProof)) != want {
+				t.Errorf("accumulator proof for leaf %d of %d does not reach the root: %v", i, n, err)
+			}
+		}
+	}
+}
+
+// millionLeafTree is built once and shared by the proof benchmarks
+var millionLeafTree *MerkleTree
+
+func benchTree(b *testing.B) *MerkleTree {
+	b.Helper()
+	if millionLeafTree == nil {
+		leaves := make([][]byte, 1<<20)
+		for i := range leaves {
+			leaves[i] = LeafHash(binary.BigEndian.AppendUint64(nil, uint64(i)))
+		}
+		millionLeafTree = NewMerkleTreeFromHashes(leaves)
+	}
+	b.ResetTimer()
+	return millionLeafTree
+}
+
+func BenchmarkGenerateProof1M(b *testing.B) {
+	tree := benchTree(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.GenerateProofAt(i * 7919 % tree.Len()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateProofByHash1M(b *testing.B) {
+	tree := benchTree(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.GenerateProofByHash(tree.Leaf(i * 7919 % tree.Len())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
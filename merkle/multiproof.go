@@ -0,0 +1,209 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const multiProofVersion = 1
+
+var ErrMalformedProof = errors.New("malformed merkle proof")
+
+// MultiProof proves several leaves of one tree at once. Sibling hashes that
+// can be computed from the proved leaves themselves are left out, so
+// neighbouring leaves share the upper part of their paths.
+type MultiProof struct {
+	Size    int      // number of leaves in the tree
+	Indices []int    // proved leaf indices, strictly increasing
+	Hashes  [][]byte // missing sibling hashes in the order the verifier consumes them
+}
+
+// GenerateMultiProof proves the leaves at the given indices
+func (mt *MerkleTree) GenerateMultiProof(indices []int) (*MultiProof, error) {
+	known := uniqueSorted(indices)
+	if len(known) == 0 {
+		return nil, fmt.Errorf("no leaves to prove")
+	}
+	for _, i := range known {
+		if i < 0 || i >= mt.Len() {
+			return nil, fmt.Errorf("leaf index %d out of range [0, %d)", i, mt.Len())
+		}
+	}
+
+	proof := &MultiProof{Size: mt.Len(), Indices: known}
+	for _, level := range mt.levels[:len(mt.levels)-1] {
+		var next []int
+		for k, i := range known {
+			sibling := i ^ 1
+			switch {
+			case sibling >= len(level):
+				// promoted node
+			case i%2 == 0 && k+1 < len(known) && known[k+1] == sibling:
+				// right sibling is itself known
+			case i%2 == 1 && k > 0 && known[k-1] == sibling:
+				// pair already handled from the left
+				continue
+			default:
+				proof.Hashes = append(proof.Hashes, level[sibling])
+			}
+			next = append(next, i/2)
+		}
+		known = next
+	}
+	return proof, nil
+}
+
+// ComputeRoot folds the proof over the leaf hashes, given in the order of
+// Indices, and returns the implied root
+func (p *MultiProof) ComputeRoot(leafHashes [][]byte) ([]byte, error) {
+	if len(p.Indices) == 0 || len(leafHashes) != len(p.Indices) {
+		return nil, fmt.Errorf("%w: %d leaves for %d indices", ErrMalformedProof, len(leafHashes), len(p.Indices))
+	}
+	for k, i := range p.Indices {
+		if i < 0 || i >= p.Size || (k > 0 && i <= p.Indices[k-1]) {
+			return nil, fmt.Errorf("%w: bad leaf index %d", ErrMalformedProof, i)
+		}
+	}
+
+	known := append([]int{}, p.Indices...)
+	hashes := append([][]byte{}, leafHashes...)
+	remaining := p.Hashes
+	pop := func() ([]byte, error) {
+		if len(remaining) == 0 {
+			return nil, fmt.Errorf("%w: too few hashes", ErrMalformedProof)
+		}
+		h := remaining[0]
+		remaining = remaining[1:]
+		return h, nil
+	}
+
+	for size := p.Size; size > 1; size = (size + 1) / 2 {
+		var nextKnown []int
+		var nextHashes [][]byte
+		for k := 0; k < len(known); k++ {
+			i, h := known[k], hashes[k]
+			sibling := i ^ 1
+			var parent []byte
+			switch {
+			case sibling >= size:
+				parent = h
+			case i%2 == 0 && k+1 < len(known) && known[k+1] == sibling:
+				parent = NodeHash(h, hashes[k+1])
+				k++
+			default:
+				s, err := pop()
+				if err != nil {
+					return nil, err
+				}
+				if i%2 == 0 {
+					parent = NodeHash(h, s)
+				} else {
+					parent = NodeHash(s, h)
+				}
+			}
+			nextKnown = append(nextKnown, i/2)
+			nextHashes = append(nextHashes, parent)
+		}
+		known, hashes = nextKnown, nextHashes
+	}
+	if len(remaining) != 0 {
+		return nil, fmt.Errorf("%w: %d unused hashes", ErrMalformedProof, len(remaining))
+	}
+	return hashes[0], nil
+}
+
+// BatchVerify checks that every leaf hash is committed to by root at the
+// proof's indices
+func BatchVerify(root []byte, proof *MultiProof, leafHashes [][]byte) error {
+	computed, err := proof.ComputeRoot(leafHashes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computed, root) {
+		return fmt.Errorf("multi-proof root %x does not match %x", computed, root)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the proof as a version byte followed by the tree
+// size, the indices and the hashes, all counts being big-endian uint32s
+func (p *MultiProof) MarshalBinary() ([]byte, error) {
+	buf := []byte{multiProofVersion}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(p.Size))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(p.Indices)))
+	for _, i := range p.Indices {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(i))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(p.Hashes)))
+	for _, h := range p.Hashes {
+		if len(h) != hashSize {
+			return nil, fmt.Errorf("%w: hash of %d bytes", ErrMalformedProof, len(h))
+		}
+		buf = append(buf, h...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a proof produced by MarshalBinary
+func (p *MultiProof) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 || data[0] != multiProofVersion {
+		return fmt.Errorf("%w: unsupported version", ErrMalformedProof)
+	}
+	data = data[1:]
+	u32 := func() (int, error) {
+		if len(data) < 4 {
+			return 0, fmt.Errorf("%w: truncated", ErrMalformedProof)
+		}
+		v := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		return int(v), nil
+	}
+
+	size, err := u32()
+	if err != nil {
+		return err
+	}
+	count, err := u32()
+	if err != nil {
+		return err
+	}
+	if count > len(data)/4 {
+		return fmt.Errorf("%w: truncated", ErrMalformedProof)
+	}
+	indices := make([]int, count)
+	for k := range indices {
+		if indices[k], err = u32(); err != nil {
+			return err
+		}
+	}
+	count, err = u32()
+	if err != nil {
+		return err
+	}
+	if len(data) != count*hashSize {
+		return fmt.Errorf("%w: expected %d hashes in %d bytes", ErrMalformedProof, count, len(data))
+	}
+	hashes := make([][]byte, count)
+	for k := range hashes {
+		hashes[k] = append([]byte(nil), data[k*hashSize:(k+1)*hashSize]...)
+	}
+
+	p.Size, p.Indices, p.Hashes = size, indices, hashes
+	return nil
+}
+
+func uniqueSorted(indices []int) []int {
+	out := append([]int{}, indices...)
+	sort.Ints(out)
+	n := 0
+	for k, i := range out {
+		if k == 0 || i != out[n-1] {
+			out[n] = i
+			n++
+		}
+	}
+	return out[:n]
+}
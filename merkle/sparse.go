@@ -0,0 +1,229 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	sparseDepth        = 256
+	sparseProofVersion = 1
+)
+
+// sparseDefaults[h] is the hash of an empty subtree of height h
+var sparseDefaults = func() [][]byte {
+	d := make([][]byte, sparseDepth+1)
+	d[0] = make([]byte, hashSize)
+	for h := 0; h < sparseDepth; h++ {
+		d[h+1] = NodeHash(d[h], d[h])
+	}
+	return d
+}()
+
+// SparseKey addresses a leaf of a SparseMerkleTree
+type SparseKey [32]byte
+
+// KeyFor derives the sparse tree key for arbitrary data
+func KeyFor(data []byte) SparseKey {
+	return sha256.Sum256(data)
+}
+
+// bit returns the i-th bit of the key counting from the most significant,
+// which is the branch taken at depth i below the root
+func (k SparseKey) bit(i int) byte {
+	return (k[i/8] >> (7 - i%8)) & 1
+}
+
+// prefix keeps the first n bits of the key and zeroes the rest
+func (k SparseKey) prefix(n int) SparseKey {
+	var p SparseKey
+	copy(p[:], k[:n/8])
+	if n%8 != 0 {
+		p[n/8] = k[n/8] & (0xff << (8 - n%8))
+	}
+	return p
+}
+
+type sparseNodeID struct {
+	height int
+	prefix SparseKey
+}
+
+// SparseMerkleTree is a Merkle tree with one leaf slot for every 256-bit
+// key. Only non-empty subtrees are stored, and because empty slots hash to
+// a known default, it can prove that a key is absent as well as present.
+type SparseMerkleTree struct {
+	values map[SparseKey][]byte
+	nodes  map[sparseNodeID][]byte
+	root   []byte
+}
+
+func NewSparseMerkleTree() *SparseMerkleTree {
+	return &SparseMerkleTree{
+		values: make(map[SparseKey][]byte),
+		nodes:  make(map[sparseNodeID][]byte),
+		root:   sparseDefaults[sparseDepth],
+	}
+}
+
+// sparseLeafHash commits to both the key and the value of a present leaf
+func sparseLeafHash(key SparseKey, value []byte) []byte {
+	valueHash := sha256.Sum256(value)
+	return LeafHash(append(key[:], valueHash[:]...))
+}
+
+// Root returns the tree root
+func (t *SparseMerkleTree) Root() []byte {
+	return t.root
+}
+
+// Len returns the number of present keys
+func (t *SparseMerkleTree) Len() int {
+	return len(t.values)
+}
+
+// Get returns the value stored at key
+func (t *SparseMerkleTree) Get(key SparseKey) ([]byte, bool) {
+	v, ok := t.values[key]
+	return v, ok
+}
+
+// Update stores value at key, replacing any previous value
+func (t *SparseMerkleTree) Update(key SparseKey, value []byte) {
+	t.values[key] = append([]byte(nil), value...)
+	t.rehash(key, sparseLeafHash(key, value))
+}
+
+// Delete empties the slot at key
+func (t *SparseMerkleTree) Delete(key SparseKey) {
+	if _, ok := t.values[key]; !ok {
+		return
+	}
+	delete(t.values, key)
+	t.rehash(key, sparseDefaults[0])
+}
+
+// rehash updates the path from the leaf at key to the root
+func (t *SparseMerkleTree) rehash(key SparseKey, leaf []byte) {
+	current := leaf
+	for h := 0; h < sparseDepth; h++ {
+		id := sparseNodeID{height: h, prefix: key.prefix(sparseDepth - h)}
+		if bytes.Equal(current, sparseDefaults[h]) {
+			delete(t.nodes, id)
+		} else {
+			t.nodes[id] = current
+		}
+		sibling := t.sibling(key, h)
+		if key.bit(sparseDepth-1-h) == 0 {
+			current = NodeHash(current, sibling)
+		} else {
+			current = NodeHash(sibling, current)
+		}
+	}
+	t.root = current
+}
+
+func (t *SparseMerkleTree) sibling(key SparseKey, height int) []byte {
+	depth := sparseDepth - height
+	p := key.prefix(depth)
+	p[(depth-1)/8] ^= 1 << (7 - (depth-1)%8)
+	if h, ok := t.nodes[sparseNodeID{height: height, prefix: p}]; ok {
+		return h
+	}
+	return sparseDefaults[height]
+}
+
+// SparseProof holds the sibling hashes from a leaf slot to the root, leaf
+// level first. Empty siblings are nil and are not encoded.
+type SparseProof struct {
+	Siblings [][]byte
+}
+
+// Prove returns a proof for key, which proves inclusion of its value if the
+// key is present and non-inclusion otherwise
+func (t *SparseMerkleTree) Prove(key SparseKey) *SparseProof {
+	proof := &SparseProof{Siblings: make([][]byte, sparseDepth)}
+	for h := 0; h < sparseDepth; h++ {
+		if s := t.sibling(key, h); !bytes.Equal(s, sparseDefaults[h]) {
+			proof.Siblings[h] = s
+		}
+	}
+	return proof
+}
+
+// computeRoot folds the proof over a leaf hash at key
+func (p *SparseProof) computeRoot(key SparseKey, leaf []byte) ([]byte, error) {
+	if len(p.Siblings) != sparseDepth {
+		return nil, fmt.Errorf("%w: %d siblings", ErrMalformedProof, len(p.Siblings))
+	}
+	current := leaf
+	for h, sibling := range p.Siblings {
+		if sibling == nil {
+			sibling = sparseDefaults[h]
+		}
+		if key.bit(sparseDepth-1-h) == 0 {
+			current = NodeHash(current, sibling)
+		} else {
+			current = NodeHash(sibling, current)
+		}
+	}
+	return current, nil
+}
+
+// VerifyInclusion checks that key maps to value under root
+func (p *SparseProof) VerifyInclusion(root []byte, key SparseKey, value []byte) bool {
+	computed, err := p.computeRoot(key, sparseLeafHash(key, value))
+	return err == nil && bytes.Equal(computed, root)
+}
+
+// VerifyNonInclusion checks that key has no value under root
+func (p *SparseProof) VerifyNonInclusion(root []byte, key SparseKey) bool {
+	computed, err := p.computeRoot(key, sparseDefaults[0])
+	return err == nil && bytes.Equal(computed, root)
+}
+
+// MarshalBinary encodes the proof as a version byte, a 256-bit bitmap of
+// non-empty siblings and then those siblings, leaf level first
+func (p *SparseProof) MarshalBinary() ([]byte, error) {
+	if len(p.Siblings) != sparseDepth {
+		return nil, fmt.Errorf("%w: %d siblings", ErrMalformedProof, len(p.Siblings))
+	}
+	buf := make([]byte, 1+sparseDepth/8)
+	buf[0] = sparseProofVersion
+	for h, s := range p.Siblings {
+		if s == nil {
+			continue
+		}
+		if len(s) != hashSize {
+			return nil, fmt.Errorf("%w: hash of %d bytes", ErrMalformedProof, len(s))
+		}
+		buf[1+h/8] |= 1 << (h % 8)
+		buf = append(buf, s...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a proof produced by MarshalBinary
+func (p *SparseProof) UnmarshalBinary(data []byte) error {
+	if len(data) < 1+sparseDepth/8 || data[0] != sparseProofVersion {
+		return fmt.Errorf("%w: bad sparse proof header", ErrMalformedProof)
+	}
+	bitmap, rest := data[1:1+sparseDepth/8], data[1+sparseDepth/8:]
+	siblings := make([][]byte, sparseDepth)
+	for h := range siblings {
+		if bitmap[h/8]&(1<<(h%8)) == 0 {
+			continue
+		}
+		if len(rest) < hashSize {
+			return fmt.Errorf("%w: truncated", ErrMalformedProof)
+		}
+		siblings[h] = append([]byte(nil), rest[:hashSize]...)
+		rest = rest[hashSize:]
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("%w: trailing bytes", ErrMalformedProof)
+	}
+	p.Siblings = siblings
+	return nil
+}
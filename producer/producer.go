@@ -0,0 +1,130 @@
+package producer
+
+import (
+	"blockchain_A3/consensus"
+	"blockchain_A3/core"
+	"blockchain_A3/mempool"
+	"blockchain_A3/state"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Config controls the shape of produced blocks
+type Config struct {
+	Proposer        string      // address credited with the coinbase
+	Reward          core.Amount // block reward minted on top of fees
+	MaxTransactions int         // maximum transactions per block, excluding the coinbase
+	MaxBlockBytes   int         // maximum total encoded size of the block's transactions
+}
+
+// DefaultConfig returns limits suitable for a single node
+func DefaultConfig(proposer string, reward core.Amount) Config {
+	return Config{
+		Proposer:        proposer,
+		Reward:          reward,
+		MaxTransactions: 500,
+		MaxBlockBytes:   1 << 20,
+	}
+}
+
+// Producer assembles blocks from the mempool, seals them with a consensus
+// engine and commits them to the chain
+type Producer struct {
+	chain  *core.Blockchain
+	pool   *mempool.Mempool
+	engine consensus.Engine
+	cfg    Config
+}
+
+// New creates a block producer
+func New(chain *core.Blockchain, pool *mempool.Mempool, engine consensus.Engine, cfg Config) *Producer {
+	return &Producer{chain: chain, pool: pool, engine: engine, cfg: cfg}
+}
+
+// ProduceBlock builds a block from the best pending transactions, seals it
+// and commits it. Transactions the chain state rejects are dropped from the
+// pool and the block is rebuilt without them.
+func (p *Producer) ProduceBlock(ctx context.Context) (*core.Block, error) {
+	selected, err := p.selectTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	var block *core.Block
+	for {
+		block, err = p.prepare(selected)
+		if err == nil {
+			break
+		}
+
+		var txErr *state.TxError
+		if !errors.As(err, &txErr) || txErr.Index == 0 {
+			return nil, err
+		}
+		// Drop the offending transaction; the coinbase sits at index 0
+		bad := selected[txErr.Index-1]
+		p.pool.Remove(bad.Hash())
+		selected = dropSender(selected, txErr.Index-1)
+	}
+
+	if err := p.engine.Seal(ctx, p.chain, block); err != nil {
+		return nil, fmt.Errorf("failed to seal block %d: %w", block.Index, err)
+	}
+	if err := p.chain.AcceptBlock(block); err != nil {
+		return nil, fmt.Errorf("failed to commit block %d: %w", block.Index, err)
+	}
+	p.pool.RemoveBlock(block)
+	return block, nil
+}
+
+// selectTransactions takes pending transactions in priority order until the
+// block's count or size limit is reached
+func (p *Producer) selectTransactions() ([]*core.Transaction, error) {
+	var selected []*core.Transaction
+	size := 0
+	for _, tx := range p.pool.Pending(0) {
+		if p.cfg.MaxTransactions > 0 && len(selected) >= p.cfg.MaxTransactions {
+			break
+		}
+		encoded, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if p.cfg.MaxBlockBytes > 0 && size+len(encoded) > p.cfg.MaxBlockBytes {
+			continue
+		}
+		selected = append(selected, tx)
+		size += len(encoded)
+	}
+	return selected, nil
+}
+
+// prepare adds the coinbase and builds a candidate block on the chain head
+func (p *Producer) prepare(selected []*core.Transaction) (*core.Block, error) {
+	var fees core.Amount
+	for _, tx := range selected {
+		fees += tx.Fee
+	}
+	height := p.chain.Height() + 1
+	coinbase := core.NewCoinbaseTransaction(p.chain.ChainID(), p.cfg.Proposer, p.cfg.Reward+fees, height)
+
+	txs := make([]*core.Transaction, 0, len(selected)+1)
+	txs = append(txs, coinbase)
+	txs = append(txs, selected...)
+	return p.chain.PrepareBlock(txs)
+}
+
+// dropSender removes the transaction at index i along with every later
+// transaction from the same sender, whose nonces now cannot be satisfied
+func dropSender(txs []*core.Transaction, i int) []*core.Transaction {
+	sender := txs[i].Sender
+	kept := make([]*core.Transaction, 0, len(txs)-1)
+	kept = append(kept, txs[:i]...)
+	for _, tx := range txs[i+1:] {
+		if tx.Sender != sender {
+			kept = append(kept, tx)
+		}
+	}
+	return kept
+}
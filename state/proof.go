@@ -0,0 +1,67 @@
+package state
+
+import (
+	"blockchain_A3/core"
+	"blockchain_A3/trie"
+	"encoding/hex"
+	"fmt"
+)
+
+// AccountProof proves the state of an account under a state root
+type AccountProof struct {
+	Address string
+	Account Account
+	Root    string
+	Nodes   [][]byte // trie nodes from the root to the account's slot
+}
+
+// ProveAccount returns a proof of the account at address under root. An
+// absent account is proven empty.
+func (db *StateDB) ProveAccount(root, address string) (*AccountProof, error) {
+	ws, err := db.StateAt(root)
+	if err != nil {
+		return nil, err
+	}
+	account, err := ws.account(address)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := ws.trie.Prove(accountKey(address))
+	if err != nil {
+		return nil, err
+	}
+	return &AccountProof{Address: address, Account: account, Root: root, Nodes: nodes}, nil
+}
+
+// AccountAt proves the account at address as of the canonical block at
+// height, answering e.g. what a balance was at block N
+func (db *StateDB) AccountAt(chain *core.Blockchain, address string, height int) (*AccountProof, error) {
+	block, err := chain.GetBlockByHeight(height)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", height, err)
+	}
+	return db.ProveAccount(block.StateRoot, address)
+}
+
+// VerifyAccountProof checks the proof's nodes against its root and that
+// they hold exactly the claimed account
+func VerifyAccountProof(proof *AccountProof) error {
+	root, err := hex.DecodeString(proof.Root)
+	if err != nil {
+		return fmt.Errorf("invalid state root %q", proof.Root)
+	}
+	data, err := trie.VerifyProof(root, accountKey(proof.Address), proof.Nodes)
+	if err != nil {
+		return err
+	}
+	var proven Account
+	if data != nil {
+		if proven, err = decodeAccount(data); err != nil {
+			return err
+		}
+	}
+	if proven != proof.Account {
+		return fmt.Errorf("proof holds %+v for %s, not %+v", proven, proof.Address, proof.Account)
+	}
+	return nil
+}
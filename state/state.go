@@ -0,0 +1,144 @@
+package state
+
+import (
+	"blockchain_A3/core"
+	"blockchain_A3/trie"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownRoot       = errors.New("unknown state root")
+	ErrMisplacedCoinbase = errors.New("coinbase must be the first transaction")
+	ErrExcessiveCoinbase = errors.New("coinbase pays more than block reward plus fees")
+)
+
+// TxError reports which transaction of a batch could not be applied
+type TxError struct {
+	Index int
+	Err   error
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("transaction %d: %v", e.Index, e.Err)
+}
+
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// StateDB persists the state trie of every state root it has produced, so
+// blocks on any fork can be executed on top of their parent's state and
+// any past state can be read back by its root. It implements
+// core.StateProcessor.
+type StateDB struct {
+	db          trie.Database
+	genesisRoot string
+	blockReward core.Amount
+}
+
+// NewStateDB creates an in-memory state database whose genesis state holds
+// alloc and whose blocks may mint at most blockReward plus their fees via
+// a coinbase
+func NewStateDB(alloc GenesisAlloc, blockReward core.Amount) (*StateDB, error) {
+	return NewStateDBWithDatabase(trie.NewMemoryDB(), alloc, blockReward)
+}
+
+// NewStateDBWithDatabase is NewStateDB storing trie nodes in db
+func NewStateDBWithDatabase(db trie.Database, alloc GenesisAlloc, blockReward core.Amount) (*StateDB, error) {
+	if blockReward < 0 {
+		return nil, fmt.Errorf("negative block reward %s", blockReward)
+	}
+	genesis, err := newWorldState(db, alloc)
+	if err != nil {
+		return nil, err
+	}
+	root, err := genesis.commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit genesis state: %v", err)
+	}
+	return &StateDB{db: db, genesisRoot: root, blockReward: blockReward}, nil
+}
+
+// BlockReward returns the amount a coinbase may mint on top of fees
+func (db *StateDB) BlockReward() core.Amount {
+	return db.blockReward
+}
+
+// Apply executes transactions in order on top of the state at parentRoot and
+// returns the new root. A leading coinbase is credited after the other
+// transactions, once their fees are known. Nothing is recorded if any
+// transaction fails; the error is a *TxError naming the offending one.
+func (db *StateDB) Apply(parentRoot string, transactions []*core.Transaction) (string, error) {
+	parent, err := db.parentState(parentRoot)
+	if err != nil {
+		return "", err
+	}
+
+	next := parent.Copy()
+	var fees core.Amount
+	var coinbase *core.Transaction
+	for i, tx := range transactions {
+		if tx.IsCoinbase() {
+			if i != 0 {
+				return "", &TxError{Index: i, Err: ErrMisplacedCoinbase}
+			}
+			coinbase = tx
+			continue
+		}
+		if err := next.ApplyTransaction(tx); err != nil {
+			return "", &TxError{Index: i, Err: err}
+		}
+		fees += tx.Fee
+	}
+
+	if coinbase != nil {
+		if coinbase.Amount < 0 || coinbase.Amount > db.blockReward+fees {
+			return "", &TxError{Index: 0, Err: fmt.Errorf("%w: %s > %s", ErrExcessiveCoinbase, coinbase.Amount, db.blockReward+fees)}
+		}
+		if err := next.credit(coinbase.Receiver, coinbase.Amount); err != nil {
+			return "", &TxError{Index: 0, Err: err}
+		}
+	}
+
+	root, err := next.commit()
+	if err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// ApplyBlock executes a block on top of its parent's post-block state and
+// returns the resulting world state
+func (db *StateDB) ApplyBlock(parent, block *core.Block) (*WorldState, error) {
+	parentRoot := ""
+	if parent != nil {
+		parentRoot = parent.StateRoot
+	}
+	root, err := db.Apply(parentRoot, block.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	return db.StateAt(root)
+}
+
+// StateAt returns the snapshot committed to by a state root
+func (db *StateDB) StateAt(root string) (*WorldState, error) {
+	hash, err := hex.DecodeString(root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRoot, root)
+	}
+	t, err := trie.New(hash, db.db)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRoot, root)
+	}
+	return &WorldState{trie: t}, nil
+}
+
+func (db *StateDB) parentState(root string) (*WorldState, error) {
+	if root == "" {
+		root = db.genesisRoot
+	}
+	return db.StateAt(root)
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"blockchain_A3/core"
+	"blockchain_A3/trie"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrInvalidAmount     = errors.New("transaction amount must be positive")
+	ErrInvalidFee        = errors.New("transaction fee must not be negative")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidNonce      = errors.New("invalid transaction nonce")
+	ErrBalanceOverflow   = errors.New("balance overflow")
+)
+
+// Account holds the balance of an address and the nonce its next
+// transaction must carry
+type Account struct {
+	Balance core.Amount
+	Nonce   uint64
+}
+
+// GenesisAlloc maps addresses to the balances they start with
+type GenesisAlloc map[string]core.Amount
+
+// accountSize is the encoded size of an Account: balance then nonce, both
+// big-endian
+const accountSize = 16
+
+// WorldState is a snapshot of every account, held in a Merkle Patricia
+// trie keyed by the SHA-256 of the address. Snapshots are never modified
+// once their root has been computed; applying transactions yields a copy.
+type WorldState struct {
+	trie *trie.Trie
+}
+
+// NewWorldState creates the genesis world state from an allocation
+func NewWorldState(alloc GenesisAlloc) (*WorldState, error) {
+	return newWorldState(trie.NewMemoryDB(), alloc)
+}
+
+func newWorldState(db trie.Database, alloc GenesisAlloc) (*WorldState, error) {
+	t, err := trie.New(nil, db)
+	if err != nil {
+		return nil, err
+	}
+	ws := &WorldState{trie: t}
+	for address, balance := range alloc {
+		if balance < 0 {
+			return nil, fmt.Errorf("negative genesis balance for %s", address)
+		}
+		if err := ws.setAccount(address, Account{Balance: balance}); err != nil {
+			return nil, err
+		}
+	}
+	return ws, nil
+}
+
+// GetAccount returns the account for an address; unknown addresses are empty
+func (ws *WorldState) GetAccount(address string) Account {
+	account, _ := ws.account(address)
+	return account
+}
+
+// Copy returns an independent copy of the world state
+func (ws *WorldState) Copy() *WorldState {
+	return &WorldState{trie: ws.trie.Copy()}
+}
+
+func (ws *WorldState) account(address string) (Account, error) {
+	data, err := ws.trie.Get(accountKey(address))
+	if err != nil || data == nil {
+		return Account{}, err
+	}
+	return decodeAccount(data)
+}
+
+// setAccount stores an account; empty accounts are removed from the trie
+func (ws *WorldState) setAccount(address string, account Account) error {
+	return ws.trie.Update(accountKey(address), encodeAccount(account))
+}
+
+func accountKey(address string) []byte {
+	key := sha256.Sum256([]byte(address))
+	return key[:]
+}
+
+func encodeAccount(account Account) []byte {
+	if account == (Account{}) {
+		return nil
+	}
+	buf := make([]byte, accountSize)
+	binary.BigEndian.PutUint64(buf, uint64(account.Balance))
+	binary.BigEndian.PutUint64(buf[8:], account.Nonce)
+	return buf
+}
+
+func decodeAccount(data []byte) (Account, error) {
+	if len(data) != accountSize {
+		return Account{}, fmt.Errorf("invalid account encoding of %d bytes", len(data))
+	}
+	return Account{
+		Balance: core.Amount(binary.BigEndian.Uint64(data)),
+		Nonce:   binary.BigEndian.Uint64(data[8:]),
+	}, nil
+}
+
+// ApplyTransaction moves funds from sender to receiver and burns the fee,
+// rejecting overdrafts and transactions whose nonce is not the sender's next nonce
+func (ws *WorldState) ApplyTransaction(tx *core.Transaction) error {
+	if tx.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if tx.Fee < 0 {
+		return ErrInvalidFee
+	}
+	if tx.Amount > math.MaxInt64-tx.Fee {
+		return fmt.Errorf("%w: amount plus fee", ErrBalanceOverflow)
+	}
+	cost := tx.Amount + tx.Fee
+
+	sender, err := ws.account(tx.Sender)
+	if err != nil {
+		return err
+	}
+	if tx.Nonce != sender.Nonce {
+		return fmt.Errorf("%w: %s expected %d, got %d", ErrInvalidNonce, tx.Sender, sender.Nonce, tx.Nonce)
+	}
+	if sender.Balance < cost {
+		return fmt.Errorf("%w: %s has %s, needs %s", ErrInsufficientFunds, tx.Sender, sender.Balance, cost)
+	}
+	sender.Balance -= cost
+	sender.Nonce++
+	if err := ws.setAccount(tx.Sender, sender); err != nil {
+		return err
+	}
+
+	return ws.credit(tx.Receiver, tx.Amount)
+}
+
+// credit adds amount to an address's balance
+func (ws *WorldState) credit(address string, amount core.Amount) error {
+	account, err := ws.account(address)
+	if err != nil {
+		return err
+	}
+	if account.Balance > math.MaxInt64-amount {
+		return fmt.Errorf("%w: %s", ErrBalanceOverflow, address)
+	}
+	account.Balance += amount
+	return ws.setAccount(address, account)
+}
+
+// Root returns the trie root committing to every account
+func (ws *WorldState) Root() string {
+	return hex.EncodeToString(ws.trie.Hash())
+}
+
+// commit persists the world state's trie nodes and returns its root
+func (ws *WorldState) commit() (string, error) {
+	root, err := ws.trie.Commit()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(root), nil
+}
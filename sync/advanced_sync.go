@@ -2,10 +2,13 @@ package sync
 
 import (
 	"blockchain_A3/amf"
+	"blockchain_A3/logging"
 	"blockchain_A3/merkle"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
 )
 
 // HomomorphicHash represents a homomorphic hash function
@@ -74,13 +77,19 @@ func VerifyCommitment(commitment *Commitment) bool {
 
 // AdvancedCrossShardTransfer performs an atomic cross-shard transfer with homomorphic authentication
 func AdvancedCrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, txIndex int) error {
+	return manager.WithShards(func(shards []*amf.Shard) error {
+		return advancedCrossShardTransfer(manager, shards, fromShardID, toShardID, txIndex)
+	})
+}
+
+func advancedCrossShardTransfer(manager *amf.ShardManager, shards []*amf.Shard, fromShardID, toShardID int, txIndex int) error {
 	// Validate shard IDs
-	if len(manager.Shards) <= fromShardID || len(manager.Shards) <= toShardID {
+	fromShard, toShard := findShard(shards, fromShardID), findShard(shards, toShardID)
+	if fromShard == nil || toShard == nil {
 		return fmt.Errorf("invalid shard IDs provided: from=%d, to=%d", fromShardID, toShardID)
 	}
-
-	fromShard := manager.Shards[fromShardID]
-	toShard := manager.Shards[toShardID]
+	unlock := amf.LockShards(fromShard, toShard)
+	defer unlock()
 
 	// Validate transaction index
 	if len(fromShard.Transactions) <= txIndex {
@@ -89,7 +98,8 @@ func AdvancedCrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardI
 
 	// Step 1: Get the transaction to transfer
 	tx := fromShard.Transactions[txIndex]
-	fmt.Printf("Initiating transfer of transaction: %s\n", tx)
+	logger := manager.Logger().With(logging.TxHash(tx), slog.Int("from_shard", fromShardID), slog.Int("to_shard", toShardID))
+	logger.Info("initiating cross-shard transfer")
 
 	// Step 2: Create homomorphic hash instance
 	homomorphicHash := NewHomomorphicHash()
@@ -101,11 +111,8 @@ func AdvancedCrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardI
 		return fmt.Errorf("commitment verification failed")
 	}
 
-	// Step 4: Build Merkle Tree with homomorphic hashes
-	merkleTree := merkle.NewMerkleTree(fromShard.Transactions)
-
-	// Step 5: Generate Merkle Proof with homomorphic verification
-	proof, err := merkleTree.GenerateProof(tx)
+	// Step 4-5: Generate Merkle Proof from the shard's accumulator
+	proof, err := fromShard.ProveTransaction(tx)
 	if err != nil {
 		return fmt.Errorf("failed to generate Merkle proof: %v", err)
 	}
@@ -116,7 +123,12 @@ func AdvancedCrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardI
 		return fmt.Errorf("merkle proof verification failed for source shard")
 	}
 
-	fmt.Println("Merkle proof verified. Proceeding with cross-shard transfer...")
+	// Step 6b: Prove the destination shard does not already hold the transaction
+	if err := verifyAbsent(toShard, tx); err != nil {
+		return err
+	}
+
+	logger.Debug("source inclusion and destination absence verified", logging.Root(fromShard.RootHash))
 
 	// Step 7: Create backup of current states
 	fromShardBackup := make([][]byte, len(fromShard.Transactions))
@@ -125,20 +137,15 @@ func AdvancedCrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardI
 	copy(toShardBackup, toShard.Transactions)
 
 	// Step 8: Remove transaction from source shard
-	fromShard.Transactions = append(fromShard.Transactions[:txIndex], fromShard.Transactions[txIndex+1:]...)
-	fromShard.States = append(fromShard.States[:txIndex], fromShard.States[txIndex+1:]...)
-	fromShard.Load = len(fromShard.Transactions)
-	fromShard.RecalculateRootHash()
+	if err := fromShard.RemoveTransaction(txIndex); err != nil {
+		return err
+	}
 
 	// Step 9: Add transaction to destination shard with commitment
-	toShard.Transactions = append(toShard.Transactions, tx)
-	toShard.States = append(toShard.States, tx)
-	toShard.Load = len(toShard.Transactions)
-	toShard.RecalculateRootHash()
+	toShard.AppendTransaction(tx)
 
 	// Step 10: Verify the new states with homomorphic verification
-	toMerkleTree := merkle.NewMerkleTree(toShard.Transactions)
-	toProof, err := toMerkleTree.GenerateProof(tx)
+	toProof, err := toShard.ProveTransaction(tx)
 	if err != nil {
 		// Rollback if proof generation fails
 		rollback(fromShard, toShard, fromShardBackup, toShardBackup)
@@ -152,9 +159,12 @@ func AdvancedCrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardI
 		return fmt.Errorf("merkle proof verification failed for destination shard")
 	}
 
-	fmt.Println("Cross-shard transfer complete.")
-	fmt.Printf("Updated Root Hash for Shard %d: %x\n", fromShardID, fromShard.RootHash)
-	fmt.Printf("Updated Root Hash for Shard %d: %x\n", toShardID, toShard.RootHash)
+	// Step 11: Keep the manager's placement pointing at the new shard
+	manager.Relocate(tx, toShard)
+
+	logger.Info("cross-shard transfer complete",
+		slog.String("from_root", hex.EncodeToString(fromShard.RootHash)),
+		slog.String("to_root", hex.EncodeToString(toShard.RootHash)))
 	return nil
 }
 
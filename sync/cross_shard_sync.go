@@ -2,18 +2,30 @@ package sync
 
 import (
 	"blockchain_A3/amf"
+	"blockchain_A3/logging"
 	"blockchain_A3/merkle"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
 )
 
+// CrossShardTransfer moves a transaction between the shards with the given
+// stable IDs, holding both shards' locks and keeping the topology steady for
+// the whole transfer
 func CrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, txIndex int) error {
+	return manager.WithShards(func(shards []*amf.Shard) error {
+		return crossShardTransfer(manager, shards, fromShardID, toShardID, txIndex)
+	})
+}
+
+func crossShardTransfer(manager *amf.ShardManager, shards []*amf.Shard, fromShardID, toShardID int, txIndex int) error {
 	// Validate shard IDs
-	if len(manager.Shards) <= fromShardID || len(manager.Shards) <= toShardID {
+	fromShard, toShard := findShard(shards, fromShardID), findShard(shards, toShardID)
+	if fromShard == nil || toShard == nil {
 		return fmt.Errorf("invalid shard IDs provided: from=%d, to=%d", fromShardID, toShardID)
 	}
-
-	fromShard := manager.Shards[fromShardID]
-	toShard := manager.Shards[toShardID]
+	unlock := amf.LockShards(fromShard, toShard)
+	defer unlock()
 
 	// Validate transaction index
 	if len(fromShard.Transactions) <= txIndex {
@@ -22,13 +34,11 @@ func CrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, t
 
 	// Step 1: Get the transaction to transfer
 	tx := fromShard.Transactions[txIndex]
-	fmt.Printf("Initiating transfer of transaction: %s\n", tx)
-
-	// Step 2: Build Merkle Tree of fromShard transactions
-	merkleTree := merkle.NewMerkleTree(fromShard.Transactions)
+	logger := manager.Logger().With(logging.TxHash(tx), slog.Int("from_shard", fromShardID), slog.Int("to_shard", toShardID))
+	logger.Info("initiating cross-shard transfer")
 
-	// Step 3: Generate Merkle Proof for the transaction
-	proof, err := merkleTree.GenerateProof(tx)
+	// Step 2-3: Generate Merkle Proof for the transaction from the shard's accumulator
+	proof, err := fromShard.ProveTransaction(tx)
 	if err != nil {
 		return fmt.Errorf("failed to generate Merkle proof: %v", err)
 	}
@@ -36,16 +46,16 @@ func CrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, t
 	// Step 4: Verify Proof against the shard's root hash
 	isValid := merkle.VerifyProof(tx, proof, fromShard.RootHash)
 	if !isValid {
-		fmt.Printf("Debug - Transaction: %s\n", string(tx))
-		fmt.Printf("Debug - Root Hash: %x\n", fromShard.RootHash)
-		fmt.Printf("Debug - Proof Length: %d\n", len(proof))
-		for i, p := range proof {
-			fmt.Printf("Debug - Proof[%d]: %x (IsLeft: %v)\n", i, p.Hash, p.IsLeft)
-		}
+		logger.Warn("source proof verification failed", logging.Shard(fromShardID), logging.Root(fromShard.RootHash), slog.Int("steps", len(proof)))
 		return fmt.Errorf("merkle proof verification failed for source shard")
 	}
 
-	fmt.Println("Merkle proof verified. Proceeding with cross-shard transfer...")
+	// Step 4b: Prove the destination shard does not already hold the transaction
+	if err := verifyAbsent(toShard, tx); err != nil {
+		return err
+	}
+
+	logger.Debug("source inclusion and destination absence verified", logging.Root(fromShard.RootHash))
 
 	// Step 5: Create backup of current states
 	fromShardBackup := make([][]byte, len(fromShard.Transactions))
@@ -54,21 +64,16 @@ func CrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, t
 	copy(toShardBackup, toShard.Transactions)
 
 	// Step 6: Remove transaction from source shard first
-	fromShard.Transactions = append(fromShard.Transactions[:txIndex], fromShard.Transactions[txIndex+1:]...)
-	fromShard.States = append(fromShard.States[:txIndex], fromShard.States[txIndex+1:]...)
-	fromShard.Load = len(fromShard.Transactions)
-	fromShard.RecalculateRootHash()
+	if err := fromShard.RemoveTransaction(txIndex); err != nil {
+		return err
+	}
 
 	// Step 7: Add transaction to destination shard in a consistent position
 	// Always append to the end to maintain consistent ordering
-	toShard.Transactions = append(toShard.Transactions, tx)
-	toShard.States = append(toShard.States, tx)
-	toShard.Load = len(toShard.Transactions)
-	toShard.RecalculateRootHash()
+	toShard.AppendTransaction(tx)
 
 	// Step 8: Verify the new states
-	fromMerkleTree := merkle.NewMerkleTree(fromShard.Transactions)
-	_, err = fromMerkleTree.GenerateProof(tx)
+	_, err = fromShard.ProveTransaction(tx)
 	if err == nil {
 		// If proof generation succeeds, it means the transaction wasn't properly removed
 		rollbackShardState(fromShard, toShard, fromShardBackup, toShardBackup)
@@ -76,8 +81,7 @@ func CrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, t
 	}
 
 	// Step 9: Verify destination shard state
-	toMerkleTree := merkle.NewMerkleTree(toShard.Transactions)
-	toProof, err := toMerkleTree.GenerateProof(tx)
+	toProof, err := toShard.ProveTransaction(tx)
 	if err != nil {
 		// Rollback if proof generation fails
 		rollbackShardState(fromShard, toShard, fromShardBackup, toShardBackup)
@@ -87,21 +91,41 @@ func CrossShardTransfer(manager *amf.ShardManager, fromShardID, toShardID int, t
 	// Step 10: Verify the proof against the new root hash
 	isValid = merkle.VerifyProof(tx, toProof, toShard.RootHash)
 	if !isValid {
-		fmt.Printf("Debug - Destination verification failed:\n")
-		fmt.Printf("Transaction: %s\n", string(tx))
-		fmt.Printf("Root Hash: %x\n", toShard.RootHash)
-		fmt.Printf("Proof Length: %d\n", len(toProof))
-		for i, p := range toProof {
-			fmt.Printf("Proof[%d]: %x (IsLeft: %v)\n", i, p.Hash, p.IsLeft)
-		}
+		logger.Warn("destination proof verification failed", logging.Shard(toShardID), logging.Root(toShard.RootHash), slog.Int("steps", len(toProof)))
 		// Rollback if verification fails
 		rollbackShardState(fromShard, toShard, fromShardBackup, toShardBackup)
 		return fmt.Errorf("merkle proof verification failed for destination shard")
 	}
 
-	fmt.Println("Cross-shard transfer complete.")
-	fmt.Printf("Updated Root Hash for Shard %d: %x\n", fromShardID, fromShard.RootHash)
-	fmt.Printf("Updated Root Hash for Shard %d: %x\n", toShardID, toShard.RootHash)
+	// Step 11: Keep the manager's placement pointing at the new shard
+	manager.Relocate(tx, toShard)
+
+	logger.Info("cross-shard transfer complete",
+		slog.String("from_root", hex.EncodeToString(fromShard.RootHash)),
+		slog.String("to_root", hex.EncodeToString(toShard.RootHash)))
+	return nil
+}
+
+// findShard returns the shard with the given stable ID
+func findShard(shards []*amf.Shard, id int) *amf.Shard {
+	for _, shard := range shards {
+		if shard.ID == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+// verifyAbsent checks a non-inclusion proof for tx against the shard's
+// published membership root, so a transaction cannot be transferred in twice
+func verifyAbsent(shard *amf.Shard, tx []byte) error {
+	absence, err := shard.ProveAbsence(tx)
+	if err != nil {
+		return fmt.Errorf("destination shard already holds transaction: %v", err)
+	}
+	if !absence.VerifyNonInclusion(shard.MembershipRoot, merkle.KeyFor(tx)) {
+		return fmt.Errorf("non-inclusion proof verification failed for destination shard")
+	}
 	return nil
 }
 
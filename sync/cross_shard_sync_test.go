@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"blockchain_A3/amf"
+	"fmt"
+	stdsync "sync"
+	"testing"
+)
+
+// TestConcurrentShardOperations runs placement, transfers, rebalancing,
+// beacon commits and metrics reads in parallel; run it with -race
+func TestConcurrentShardOperations(t *testing.T) {
+	const (
+		adders = 4
+		perAdd = 100
+		rounds = 50
+	)
+	manager := amf.NewShardManager(amf.DefaultShardPolicy())
+
+	var wg stdsync.WaitGroup
+	errs := make(chan error, adders)
+	for a := 0; a < adders; a++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			for i := 0; i < perAdd; i++ {
+				if err := manager.AddTransaction([]byte(fmt.Sprintf("User%d-%d -> User%d: %d", a, i, i, i))); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(a)
+	}
+
+	// transfers between whichever shards exist; they fail harmlessly when
+	// a rebalance has retired a shard or emptied it in the meantime
+	transfer := func(move func(m *amf.ShardManager, from, to, index int) error) {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			metrics := manager.Metrics()
+			if len(metrics) < 2 {
+				continue
+			}
+			from, to := metrics[i%len(metrics)].ShardID, metrics[(i+1)%len(metrics)].ShardID
+			_ = move(manager, from, to, 0)
+		}
+	}
+	wg.Add(2)
+	go transfer(CrossShardTransfer)
+	go transfer(AdvancedCrossShardTransfer)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if err := manager.Rebalance(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if _, err := manager.CommitBeacon(); err != nil {
+				t.Error(err)
+				return
+			}
+			manager.Topology()
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// every transaction is held by exactly one shard, the one it is located in
+	seen := make(map[string]int)
+	err := manager.WithShards(func(shards []*amf.Shard) error {
+		for _, shard := range shards {
+			for _, tx := range shard.Transactions {
+				if _, dup := seen[string(tx)]; dup {
+					return fmt.Errorf("%q held twice", tx)
+				}
+				seen[string(tx)] = shard.ID
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != adders*perAdd {
+		t.Fatalf("shards hold %d transactions, want %d", len(seen), adders*perAdd)
+	}
+	for tx, id := range seen {
+		if shard := manager.Locate([]byte(tx)); shard == nil || shard.ID != id {
+			t.Fatalf("%q is held by shard %d but located elsewhere", tx, id)
+		}
+	}
+	for _, change := range manager.Changes() {
+		if err := amf.VerifyRestructuring(change.Proof); err != nil {
+			t.Fatalf("%s: %v", change, err)
+		}
+	}
+}
+
+func TestTransferChecksPublishedMembershipRoot(t *testing.T) {
+	manager := amf.NewShardManager(amf.DefaultShardPolicy())
+	for i := 0; i < 30; i++ {
+		if err := manager.AddTransaction([]byte(fmt.Sprintf("User%d -> User%d: 1", i, i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(manager.Shards) < 2 {
+		t.Fatalf("%d shards after filling past MaxLoad, want a split", len(manager.Shards))
+	}
+	from, to := manager.Shards[0], manager.Shards[1]
+
+	// a destination whose published root is not its membership tree's
+	published := to.MembershipRoot
+	to.MembershipRoot = from.MembershipRoot
+	if err := CrossShardTransfer(manager, from.ID, to.ID, 0); err == nil {
+		t.Fatal("transfer accepted against a root the destination did not publish")
+	}
+	to.MembershipRoot = published
+	if err := CrossShardTransfer(manager, from.ID, to.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,49 @@
+package trie
+
+import (
+	"errors"
+	stdsync "sync"
+)
+
+var ErrNotFound = errors.New("key not found in database")
+
+// Database is the key-value store trie nodes are persisted to, keyed by
+// node hash
+type Database interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+}
+
+// MemoryDB is an in-memory Database
+type MemoryDB struct {
+	mu stdsync.RWMutex
+	kv map[string][]byte
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{kv: make(map[string][]byte)}
+}
+
+func (db *MemoryDB) Get(key []byte) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	v, ok := db.kv[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+func (db *MemoryDB) Put(key, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.kv[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+// Len returns the number of stored entries
+func (db *MemoryDB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.kv)
+}
@@ -0,0 +1,191 @@
+package trie
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidNode = errors.New("invalid trie node encoding")
+
+const (
+	tagShort = 0x01
+	tagFull  = 0x02
+
+	refEmpty = 0x00
+	refHash  = 0x01
+	refValue = 0x02
+
+	// terminator is the nibble appended to every key, so that no key is a
+	// prefix of another and values only sit at the end of a path
+	terminator = 16
+)
+
+// node is one of *shortNode, *fullNode, hashNode or valueNode
+type node interface{}
+
+type (
+	// shortNode is a leaf when Key ends in the terminator and an extension
+	// otherwise
+	shortNode struct {
+		Key   []byte // nibbles
+		Val   node
+		flags nodeFlags
+	}
+	// fullNode branches on the next nibble; slot 16 holds a value
+	fullNode struct {
+		Children [17]node
+		flags    nodeFlags
+	}
+	// hashNode references a node persisted in the Database
+	hashNode []byte
+	// valueNode is a stored value
+	valueNode []byte
+)
+
+type nodeFlags struct {
+	hash  []byte // cached hash, nil if not yet computed
+	dirty bool   // not yet written to the Database
+}
+
+func (n *fullNode) copy() *fullNode {
+	c := *n
+	c.flags = nodeFlags{dirty: true}
+	return &c
+}
+
+// keyToNibbles splits key bytes into nibbles and appends the terminator
+func keyToNibbles(key []byte) []byte {
+	nibbles := make([]byte, len(key)*2+1)
+	for i, b := range key {
+		nibbles[i*2] = b >> 4
+		nibbles[i*2+1] = b & 0x0f
+	}
+	nibbles[len(nibbles)-1] = terminator
+	return nibbles
+}
+
+func prefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func concat(a, b []byte) []byte {
+	out := make([]byte, 0, len(a)+len(b))
+	return append(append(out, a...), b...)
+}
+
+func hashBytes(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// encodeNode returns the canonical encoding of a short or full node. Child
+// nodes must already have been hashed.
+func encodeNode(n node) []byte {
+	var buf []byte
+	switch n := n.(type) {
+	case *shortNode:
+		buf = append(buf, tagShort)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(n.Key)))
+		buf = append(buf, n.Key...)
+		buf = appendRef(buf, n.Val)
+	case *fullNode:
+		buf = append(buf, tagFull)
+		for _, child := range n.Children {
+			buf = appendRef(buf, child)
+		}
+	}
+	return buf
+}
+
+func appendRef(buf []byte, n node) []byte {
+	switch n := n.(type) {
+	case nil:
+		return append(buf, refEmpty)
+	case valueNode:
+		buf = append(buf, refValue)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(n)))
+		return append(buf, n...)
+	case hashNode:
+		return append(append(buf, refHash), n...)
+	case *shortNode:
+		return append(append(buf, refHash), n.flags.hash...)
+	case *fullNode:
+		return append(append(buf, refHash), n.flags.hash...)
+	}
+	panic(fmt.Sprintf("trie: unexpected node type %T", n))
+}
+
+// decodeNode parses a node encoding; children are returned as hash or
+// value nodes
+func decodeNode(hash, data []byte) (node, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidNode)
+	}
+	flags := nodeFlags{hash: hash}
+	rest := data[1:]
+	switch data[0] {
+	case tagShort:
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("%w: truncated key", ErrInvalidNode)
+		}
+		n := int(binary.BigEndian.Uint16(rest))
+		rest = rest[2:]
+		if len(rest) < n || n == 0 {
+			return nil, fmt.Errorf("%w: bad key length %d", ErrInvalidNode, n)
+		}
+		key := append([]byte(nil), rest[:n]...)
+		val, rest, err := decodeRef(rest[n:])
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 || val == nil {
+			return nil, fmt.Errorf("%w: malformed short node", ErrInvalidNode)
+		}
+		return &shortNode{Key: key, Val: val, flags: flags}, nil
+	case tagFull:
+		n := &fullNode{flags: flags}
+		for i := range n.Children {
+			child, r, err := decodeRef(rest)
+			if err != nil {
+				return nil, err
+			}
+			n.Children[i], rest = child, r
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("%w: trailing bytes", ErrInvalidNode)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: unknown tag %d", ErrInvalidNode, data[0])
+}
+
+func decodeRef(data []byte) (node, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: truncated reference", ErrInvalidNode)
+	}
+	switch data[0] {
+	case refEmpty:
+		return nil, data[1:], nil
+	case refHash:
+		if len(data) < 1+sha256.Size {
+			return nil, nil, fmt.Errorf("%w: truncated hash", ErrInvalidNode)
+		}
+		return hashNode(append([]byte(nil), data[1:1+sha256.Size]...)), data[1+sha256.Size:], nil
+	case refValue:
+		if len(data) < 5 {
+			return nil, nil, fmt.Errorf("%w: truncated value", ErrInvalidNode)
+		}
+		n := binary.BigEndian.Uint32(data[1:])
+		if uint64(len(data)-5) < uint64(n) {
+			return nil, nil, fmt.Errorf("%w: truncated value", ErrInvalidNode)
+		}
+		return valueNode(append([]byte(nil), data[5:5+n]...)), data[5+n:], nil
+	}
+	return nil, nil, fmt.Errorf("%w: unknown reference %d", ErrInvalidNode, data[0])
+}
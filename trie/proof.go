@@ -0,0 +1,97 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrBadProof = errors.New("invalid trie proof")
+
+// Prove returns the encodings of the nodes on the path to key, root first.
+// The proof shows the key's value if it is present and its absence
+// otherwise.
+func (t *Trie) Prove(key []byte) ([][]byte, error) {
+	t.Hash()
+	var proof [][]byte
+	nibbles := keyToNibbles(key)
+	n := t.root
+	for {
+		if h, ok := n.(hashNode); ok {
+			resolved, err := t.resolve(h)
+			if err != nil {
+				return nil, err
+			}
+			hashNodeTree(resolved)
+			n = resolved
+		}
+		switch cur := n.(type) {
+		case nil, valueNode:
+			return proof, nil
+		case *shortNode:
+			proof = append(proof, encodeNode(cur))
+			if len(nibbles) < len(cur.Key) || !bytes.Equal(cur.Key, nibbles[:len(cur.Key)]) {
+				return proof, nil
+			}
+			nibbles = nibbles[len(cur.Key):]
+			n = cur.Val
+		case *fullNode:
+			proof = append(proof, encodeNode(cur))
+			n = cur.Children[nibbles[0]]
+			nibbles = nibbles[1:]
+		}
+	}
+}
+
+// VerifyProof checks a proof produced by Prove against root and returns the
+// proven value, which is nil if the proof shows key is absent
+func VerifyProof(root, key []byte, proof [][]byte) ([]byte, error) {
+	nibbles := keyToNibbles(key)
+	if bytes.Equal(root, EmptyRoot) {
+		if len(proof) != 0 {
+			return nil, fmt.Errorf("%w: nodes given for empty trie", ErrBadProof)
+		}
+		return nil, nil
+	}
+	want := root
+	for i, enc := range proof {
+		if !bytes.Equal(hashBytes(enc), want) {
+			return nil, fmt.Errorf("%w: node %d does not match its hash", ErrBadProof, i)
+		}
+		n, err := decodeNode(want, enc)
+		if err != nil {
+			return nil, err
+		}
+		var next node
+		switch n := n.(type) {
+		case *shortNode:
+			if len(nibbles) < len(n.Key) || !bytes.Equal(n.Key, nibbles[:len(n.Key)]) {
+				next = nil
+			} else {
+				nibbles = nibbles[len(n.Key):]
+				next = n.Val
+			}
+		case *fullNode:
+			if len(nibbles) == 0 {
+				return nil, fmt.Errorf("%w: branch after the end of the key", ErrBadProof)
+			}
+			next = n.Children[nibbles[0]]
+			nibbles = nibbles[1:]
+		}
+		switch next := next.(type) {
+		case nil:
+			if i != len(proof)-1 {
+				return nil, fmt.Errorf("%w: nodes after the end of the path", ErrBadProof)
+			}
+			return nil, nil
+		case valueNode:
+			if i != len(proof)-1 || len(nibbles) != 0 {
+				return nil, fmt.Errorf("%w: value before the end of the key", ErrBadProof)
+			}
+			return []byte(next), nil
+		case hashNode:
+			want = next
+		}
+	}
+	return nil, fmt.Errorf("%w: proof ends before the path does", ErrBadProof)
+}
@@ -0,0 +1,55 @@
+package trie
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyProof(t *testing.T) {
+	tr, err := New(nil, NewMemoryDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := map[string]string{"alice": "100", "alfred": "7", "bob": "42"}
+	for k, v := range accounts {
+		if err := tr.Update([]byte(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := tr.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range accounts {
+		proof, err := tr.Prove([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := VerifyProof(root, []byte(k), proof)
+		if err != nil || string(got) != v {
+			t.Errorf("%s: proved %q, %v; want %q", k, got, err, v)
+		}
+	}
+	proof, err := tr.Prove([]byte("carol"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := VerifyProof(root, []byte("carol"), proof); err != nil || got != nil {
+		t.Errorf("carol: proved %q, %v; want absence", got, err)
+	}
+}
+
+func TestVerifyProofRejectsBranchPastKey(t *testing.T) {
+	// a leaf consuming the whole key, terminator included, that points at a
+	// branch instead of holding a value
+	key := []byte("k")
+	branch := encodeNode(&fullNode{})
+	leaf := encodeNode(&shortNode{Key: keyToNibbles(key), Val: hashNode(hashBytes(branch))})
+	proof := [][]byte{leaf, branch}
+
+	_, err := VerifyProof(hashBytes(leaf), key, proof)
+	if !errors.Is(err, ErrBadProof) {
+		t.Fatalf("got %v, want ErrBadProof", err)
+	}
+}
@@ -0,0 +1,310 @@
+// Package trie implements a Merkle Patricia trie: a radix-16 trie whose
+// nodes are addressed by the SHA-256 of their encoding, so a single root
+// hash commits to every key and value. Updates never modify existing
+// nodes, so a trie opened at an old root keeps reading that snapshot.
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// EmptyRoot is the root hash of a trie with no keys
+var EmptyRoot = func() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}()
+
+// Trie is a Merkle Patricia trie backed by a Database. It is not safe for
+// concurrent use; open one Trie per goroutine.
+type Trie struct {
+	db   Database
+	root node
+}
+
+// New opens the trie with the given root in db. A nil or empty root opens
+// an empty trie.
+func New(root []byte, db Database) (*Trie, error) {
+	t := &Trie{db: db}
+	if len(root) == 0 || bytes.Equal(root, EmptyRoot) {
+		return t, nil
+	}
+	if _, err := db.Get(root); err != nil {
+		return nil, fmt.Errorf("missing trie root %x: %w", root, err)
+	}
+	t.root = hashNode(append([]byte(nil), root...))
+	return t, nil
+}
+
+// Copy returns an independent trie sharing the same nodes
+func (t *Trie) Copy() *Trie {
+	return &Trie{db: t.db, root: t.root}
+}
+
+// Get returns the value stored for key, or nil if there is none
+func (t *Trie) Get(key []byte) ([]byte, error) {
+	return t.get(t.root, keyToNibbles(key))
+}
+
+func (t *Trie) get(n node, key []byte) ([]byte, error) {
+	switch n := n.(type) {
+	case nil:
+		return nil, nil
+	case valueNode:
+		return n, nil
+	case *shortNode:
+		if len(key) < len(n.Key) || !bytes.Equal(n.Key, key[:len(n.Key)]) {
+			return nil, nil
+		}
+		return t.get(n.Val, key[len(n.Key):])
+	case *fullNode:
+		return t.get(n.Children[key[0]], key[1:])
+	case hashNode:
+		resolved, err := t.resolve(n)
+		if err != nil {
+			return nil, err
+		}
+		return t.get(resolved, key)
+	}
+	panic(fmt.Sprintf("trie: unexpected node type %T", n))
+}
+
+// Update stores value for key; an empty value deletes the key
+func (t *Trie) Update(key, value []byte) error {
+	if len(value) == 0 {
+		return t.Delete(key)
+	}
+	_, root, err := t.insert(t.root, keyToNibbles(key), valueNode(append([]byte(nil), value...)))
+	if err != nil {
+		return err
+	}
+	t.root = root
+	return nil
+}
+
+func (t *Trie) insert(n node, key []byte, value node) (bool, node, error) {
+	if len(key) == 0 {
+		if v, ok := n.(valueNode); ok && bytes.Equal(v, value.(valueNode)) {
+			return false, n, nil
+		}
+		return true, value, nil
+	}
+	switch n := n.(type) {
+	case nil:
+		return true, &shortNode{Key: key, Val: value, flags: nodeFlags{dirty: true}}, nil
+	case *shortNode:
+		match := prefixLen(key, n.Key)
+		if match == len(n.Key) {
+			dirty, child, err := t.insert(n.Val, key[match:], value)
+			if !dirty || err != nil {
+				return false, n, err
+			}
+			return true, &shortNode{Key: n.Key, Val: child, flags: nodeFlags{dirty: true}}, nil
+		}
+		// the keys diverge inside this node, so branch at the first difference
+		branch := &fullNode{flags: nodeFlags{dirty: true}}
+		var err error
+		if _, branch.Children[n.Key[match]], err = t.insert(nil, n.Key[match+1:], n.Val); err != nil {
+			return false, n, err
+		}
+		if _, branch.Children[key[match]], err = t.insert(nil, key[match+1:], value); err != nil {
+			return false, n, err
+		}
+		if match == 0 {
+			return true, branch, nil
+		}
+		return true, &shortNode{Key: key[:match], Val: branch, flags: nodeFlags{dirty: true}}, nil
+	case *fullNode:
+		dirty, child, err := t.insert(n.Children[key[0]], key[1:], value)
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		c := n.copy()
+		c.Children[key[0]] = child
+		return true, c, nil
+	case hashNode:
+		resolved, err := t.resolve(n)
+		if err != nil {
+			return false, n, err
+		}
+		dirty, child, err := t.insert(resolved, key, value)
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		return true, child, nil
+	}
+	panic(fmt.Sprintf("trie: unexpected node type %T", n))
+}
+
+// Delete removes key from the trie
+func (t *Trie) Delete(key []byte) error {
+	_, root, err := t.delete(t.root, keyToNibbles(key))
+	if err != nil {
+		return err
+	}
+	t.root = root
+	return nil
+}
+
+func (t *Trie) delete(n node, key []byte) (bool, node, error) {
+	switch n := n.(type) {
+	case nil:
+		return false, nil, nil
+	case valueNode:
+		return true, nil, nil
+	case *shortNode:
+		match := prefixLen(key, n.Key)
+		if match < len(n.Key) {
+			return false, n, nil
+		}
+		if match == len(key) {
+			return true, nil, nil
+		}
+		dirty, child, err := t.delete(n.Val, key[len(n.Key):])
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		// a short child left behind is merged into this node's key
+		if short, ok := child.(*shortNode); ok {
+			return true, &shortNode{Key: concat(n.Key, short.Key), Val: short.Val, flags: nodeFlags{dirty: true}}, nil
+		}
+		return true, &shortNode{Key: n.Key, Val: child, flags: nodeFlags{dirty: true}}, nil
+	case *fullNode:
+		dirty, child, err := t.delete(n.Children[key[0]], key[1:])
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		c := n.copy()
+		c.Children[key[0]] = child
+
+		// a branch with a single child left collapses into a short node
+		pos := -1
+		for i, ch := range c.Children {
+			if ch != nil {
+				if pos != -1 {
+					return true, c, nil
+				}
+				pos = i
+			}
+		}
+		if pos != terminator {
+			remaining, err := t.resolveAny(c.Children[pos])
+			if err != nil {
+				return false, n, err
+			}
+			if short, ok := remaining.(*shortNode); ok {
+				return true, &shortNode{Key: concat([]byte{byte(pos)}, short.Key), Val: short.Val, flags: nodeFlags{dirty: true}}, nil
+			}
+		}
+		return true, &shortNode{Key: []byte{byte(pos)}, Val: c.Children[pos], flags: nodeFlags{dirty: true}}, nil
+	case hashNode:
+		resolved, err := t.resolve(n)
+		if err != nil {
+			return false, n, err
+		}
+		dirty, child, err := t.delete(resolved, key)
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		return true, child, nil
+	}
+	panic(fmt.Sprintf("trie: unexpected node type %T", n))
+}
+
+func (t *Trie) resolveAny(n node) (node, error) {
+	if h, ok := n.(hashNode); ok {
+		return t.resolve(h)
+	}
+	return n, nil
+}
+
+func (t *Trie) resolve(h hashNode) (node, error) {
+	data, err := t.db.Get(h)
+	if err != nil {
+		return nil, fmt.Errorf("missing trie node %x: %w", []byte(h), err)
+	}
+	return decodeNode(append([]byte(nil), h...), data)
+}
+
+// Hash returns the root hash without writing anything to the Database
+func (t *Trie) Hash() []byte {
+	if t.root == nil {
+		return EmptyRoot
+	}
+	return hashNodeTree(t.root)
+}
+
+// hashNodeTree computes and caches the hashes of n and its children
+func hashNodeTree(n node) []byte {
+	switch n := n.(type) {
+	case hashNode:
+		return n
+	case *shortNode:
+		if n.flags.hash == nil {
+			hashChild(n.Val)
+			n.flags.hash = hashBytes(encodeNode(n))
+		}
+		return n.flags.hash
+	case *fullNode:
+		if n.flags.hash == nil {
+			for _, child := range n.Children {
+				hashChild(child)
+			}
+			n.flags.hash = hashBytes(encodeNode(n))
+		}
+		return n.flags.hash
+	}
+	panic(fmt.Sprintf("trie: cannot hash node type %T", n))
+}
+
+func hashChild(n node) {
+	switch n.(type) {
+	case *shortNode, *fullNode:
+		hashNodeTree(n)
+	}
+}
+
+// Commit writes every new node to the Database and returns the root hash.
+// The trie can be reopened at that root with New.
+func (t *Trie) Commit() ([]byte, error) {
+	if t.root == nil {
+		return EmptyRoot, nil
+	}
+	root := t.Hash()
+	if err := t.commit(t.root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (t *Trie) commit(n node) error {
+	var flags *nodeFlags
+	switch n := n.(type) {
+	case *shortNode:
+		if !n.flags.dirty {
+			return nil
+		}
+		if err := t.commit(n.Val); err != nil {
+			return err
+		}
+		flags = &n.flags
+	case *fullNode:
+		if !n.flags.dirty {
+			return nil
+		}
+		for _, child := range n.Children {
+			if err := t.commit(child); err != nil {
+				return err
+			}
+		}
+		flags = &n.flags
+	default:
+		return nil
+	}
+	if err := t.db.Put(flags.hash, encodeNode(n)); err != nil {
+		return err
+	}
+	flags.dirty = false
+	return nil
+}
@@ -1,27 +1,29 @@
 package verification
 
 import (
+	"blockchain_A3/merkle"
 	"bytes"
-	"crypto/sha256"
 )
 
+// MerkleProof is the sibling path from a leaf to the root of a merkle.MerkleTree
 type MerkleProof struct {
-	Proof [][]byte
+	Proof []merkle.ProofStep
 }
 
+// VerifyMerkleProof checks that leafHash, as returned by merkle.LeafHash,
+// is committed to by rootHash
 func VerifyMerkleProof(proof *MerkleProof, leafHash, rootHash []byte) bool {
-	computed := leafHash
-
-	for _, siblingHash := range proof.Proof {
-		var combined []byte
-		if bytes.Compare(computed, siblingHash) < 0 {
-			combined = append(computed, siblingHash...)
-		} else {
-			combined = append(siblingHash, computed...)
-		}
-		hash := sha256.Sum256(combined)
-		computed = hash[:]
+	if proof == nil {
+		return false
 	}
+	return bytes.Equal(merkle.RootFromProof(leafHash, proof.Proof), rootHash)
+}
 
-	return bytes.Equal(computed, rootHash)
+// BatchVerifyMerkleProof checks a multi-leaf proof, with leafHashes in the
+// order of its indices, against a single root
+func BatchVerifyMerkleProof(proof *merkle.MultiProof, leafHashes [][]byte, rootHash []byte) bool {
+	if proof == nil {
+		return false
+	}
+	return merkle.BatchVerify(rootHash, proof, leafHashes) == nil
 }